@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directories are uploaded as an archive: the content of all the regular files one after the other, in the order
+// of their paths. The index of the archive (path, offset and size of every file) is stored in the manifest, so a
+// single file can be extracted by retrieving only the chunks that hold it.
+const archiveContentType = "application/vnd.blobtoss.archive"
+
+// ArchiveEntry is a file of an archive. Offset is the position of its content in the archive.
+type ArchiveEntry struct {
+	Path   string `json:"path"`
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+	Mode   uint32 `json:"mode,omitempty"`
+}
+
+// readArchiveIndex lists the regular files of dir and computes their position in the archive
+func readArchiveIndex(dir string) ([]ArchiveEntry, error) {
+	var (
+		entries []ArchiveEntry
+		offset  uint64
+	)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, ArchiveEntry{
+			Path:   filepath.ToSlash(rel),
+			Offset: offset,
+			Size:   uint64(info.Size()),
+			Mode:   uint32(info.Mode().Perm()),
+		})
+		offset += uint64(info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no files found in %s", dir)
+	}
+	return entries, nil
+}
+
+// findArchiveEntry returns the entry of an archive with the given path
+func findArchiveEntry(entries []ArchiveEntry, path string) (ArchiveEntry, error) {
+	for _, entry := range entries {
+		if entry.Path == path {
+			return entry, nil
+		}
+	}
+	return ArchiveEntry{}, fmt.Errorf("%s not found in archive", path)
+}
+
+// archiveEntryFilter passes the content of entry to out. The archive content written to it starts at offset.
+func archiveEntryFilter(entry ArchiveEntry, offset uint64, out func([]byte) error) func([]byte) error {
+	pos := offset
+	return func(data []byte) error {
+		base := pos
+		pos += uint64(len(data))
+
+		start, end := entry.Offset, entry.Offset+entry.Size
+		if start < base {
+			start = base
+		}
+		if end > pos {
+			end = pos
+		}
+		if start >= end {
+			return nil
+		}
+		return out(data[start-base : end-base])
+	}
+}
+
+// archiveEntryPath returns where an entry is extracted in dir. Paths escaping dir are rejected.
+func archiveEntryPath(dir, path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if path == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in archive: %q", path)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// archiveReader reads the content of the files of an archive one after the other
+type archiveReader struct {
+	dir     string
+	entries []ArchiveEntry
+	current int
+	file    *os.File
+	read    uint64 // bytes read from the current file
+}
+
+func newArchiveReader(dir string, entries []ArchiveEntry) *archiveReader {
+	return &archiveReader{dir: dir, entries: entries}
+}
+
+func (a *archiveReader) Read(p []byte) (int, error) {
+	for {
+		if a.current == len(a.entries) {
+			return 0, io.EOF
+		}
+		entry := a.entries[a.current]
+		if a.file == nil {
+			file, err := os.Open(filepath.Join(a.dir, filepath.FromSlash(entry.Path)))
+			if err != nil {
+				return 0, err
+			}
+			a.file, a.read = file, 0
+		}
+
+		n, err := a.file.Read(p)
+		a.read += uint64(n)
+		if a.read > entry.Size {
+			return 0, fmt.Errorf("%s changed while it was being read", entry.Path)
+		}
+		if err == io.EOF {
+			if a.read != entry.Size {
+				return 0, fmt.Errorf("%s changed while it was being read", entry.Path)
+			}
+			a.file.Close()
+			a.file = nil
+			a.current++
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (a *archiveReader) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// archiveExtractor writes the files of an archive to dir as its content is written to it. The content may start
+// at any offset of the archive, which is used to extract a single entry.
+type archiveExtractor struct {
+	dir     string
+	entries []ArchiveEntry
+	current int
+	pos     uint64
+	file    *os.File
+}
+
+func newArchiveExtractor(dir string, entries []ArchiveEntry, offset uint64) (*archiveExtractor, error) {
+	for i, entry := range entries {
+		if _, err := archiveEntryPath(dir, entry.Path); err != nil {
+			return nil, err
+		}
+		if i > 0 && entry.Offset < entries[i-1].Offset+entries[i-1].Size {
+			return nil, fmt.Errorf("invalid archive index: %s overlaps %s", entry.Path, entries[i-1].Path)
+		}
+	}
+	return &archiveExtractor{dir: dir, entries: entries, pos: offset}, nil
+}
+
+func (x *archiveExtractor) Write(data []byte) error {
+	for len(data) > 0 {
+		if x.current == len(x.entries) {
+			return errors.New("archive is longer than its index")
+		}
+		entry := x.entries[x.current]
+		if x.pos >= entry.Offset+entry.Size {
+			if err := x.next(); err != nil {
+				return err
+			}
+			continue
+		}
+		if x.pos < entry.Offset {
+			skip := entry.Offset - x.pos
+			if skip > uint64(len(data)) {
+				skip = uint64(len(data))
+			}
+			x.pos += skip
+			data = data[skip:]
+			continue
+		}
+
+		if x.file == nil {
+			if err := x.create(entry); err != nil {
+				return err
+			}
+		}
+		n := entry.Offset + entry.Size - x.pos
+		if n > uint64(len(data)) {
+			n = uint64(len(data))
+		}
+		if _, err := x.file.Write(data[:n]); err != nil {
+			return err
+		}
+		x.pos += n
+		data = data[n:]
+	}
+	return nil
+}
+
+func (x *archiveExtractor) create(entry ArchiveEntry) error {
+	path, err := archiveEntryPath(x.dir, entry.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode := fs.FileMode(entry.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	x.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	return err
+}
+
+// next closes the current entry, creating it if it is empty
+func (x *archiveExtractor) next() error {
+	entry := x.entries[x.current]
+	if x.file == nil {
+		if err := x.create(entry); err != nil {
+			return err
+		}
+	}
+	if err := x.file.Close(); err != nil {
+		return err
+	}
+	x.file = nil
+	x.current++
+	fmt.Printf("Extracted %s (%d bytes)\n", entry.Path, entry.Size)
+	return nil
+}
+
+// Close checks that all the entries have been written
+func (x *archiveExtractor) Close() error {
+	for x.current < len(x.entries) {
+		entry := x.entries[x.current]
+		if x.pos < entry.Offset+entry.Size {
+			if x.file != nil {
+				x.file.Close()
+			}
+			return fmt.Errorf("archive is truncated: %s is incomplete", entry.Path)
+		}
+		if err := x.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
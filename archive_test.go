@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+// fakeBeaconNode serves one blob per slot starting at firstSlot and records the highest slot requested
+type fakeBeaconNode struct {
+	firstSlot int
+	blobs     []kzg4844.Blob
+
+	mu      sync.Mutex
+	maxSlot int
+}
+
+func (f *fakeBeaconNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	slot, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/eth/v1/beacon/blob_sidecars/"))
+	if err != nil {
+		http.Error(w, "invalid slot", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	if slot > f.maxSlot {
+		f.maxSlot = slot
+	}
+	f.mu.Unlock()
+
+	var response BlobResponse
+	if index := slot - f.firstSlot; index >= 0 && index < len(f.blobs) {
+		response.Data = append(response.Data, BlobSidecar{Index: "0", Blob: hexutil.Encode(f.blobs[index][:])})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeTestTree(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	chunkSize := chunkCapacity(EncodeOptions{})
+	files := map[string][]byte{
+		"a.txt":     makeBlob(100),
+		"c.txt":     []byte("hello"),
+		"d/empty":   {},
+		"z/big.bin": makeBlob(3*chunkSize + 10),
+	}
+	src := t.TempDir()
+	writeTestTree(t, src, files)
+
+	entries, err := readArchiveIndex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(files) {
+		t.Fatalf("expected %d entries, got %d", len(files), len(entries))
+	}
+	enc, err := newBlobEncoder(newArchiveReader(src, entries), EncodeOptions{Archive: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var blobs []kzg4844.Blob
+	for {
+		blob, err := enc.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, *blob)
+	}
+
+	node := &fakeBeaconNode{firstSlot: 100, blobs: blobs}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	download := func(opts DownloadOptions) {
+		blobChannel := make(chan []byte)
+		errChannel := make(chan error, 1)
+		go func() {
+			errChannel <- GetMultiPartBlob(blobChannel, server.URL, node.firstSlot, opts)
+		}()
+		for range blobChannel {
+		}
+		if err := <-errChannel; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Restore the whole tree
+	out := t.TempDir()
+	download(DownloadOptions{SaveFiles: true, OutputDir: out})
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, got) {
+			t.Fatalf("%s: expected %d bytes, got %d", name, len(data), len(got))
+		}
+	}
+
+	// c.txt is in the first chunk, so the download stops at the second blob
+	node.maxSlot = 0
+	out = t.TempDir()
+	download(DownloadOptions{SaveFiles: true, OutputDir: out, Path: "c.txt"})
+	if got, err := os.ReadFile(filepath.Join(out, "c.txt")); err != nil || !bytes.Equal(got, files["c.txt"]) {
+		t.Fatalf("c.txt: unexpected content %q (%v)", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("only c.txt should be extracted")
+	}
+	if node.maxSlot != node.firstSlot+1 {
+		t.Fatalf("expected the download to stop at slot %d, got %d", node.firstSlot+1, node.maxSlot)
+	}
+}
+
+func TestArchiveEntryPath(t *testing.T) {
+	for _, path := range []string{"", "../x", "/etc/passwd", "a/../../x"} {
+		if _, err := archiveEntryPath("out", path); err == nil {
+			t.Fatalf("expected error for %q", path)
+		}
+	}
+	if path, err := archiveEntryPath("out", "a/b"); err != nil || path != filepath.Join("out", "a", "b") {
+		t.Fatalf("unexpected path %q (%v)", path, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/urfave/cli"
+)
+
+// randomBlobs returns n blobs of random field elements
+func randomBlobs(n int) []kzg4844.Blob {
+	rng := rand.New(rand.NewSource(4844))
+	blobs := make([]kzg4844.Blob, n)
+	for i := range blobs {
+		for fe := 0; fe < len(blobs[i]); fe += 32 {
+			// The first byte stays zero so the field element is below the modulus
+			rng.Read(blobs[i][fe+1 : fe+32])
+		}
+	}
+	return blobs
+}
+
+// benchKZG returns the commitments and proofs per second computed by backend with `concurrency` workers
+func benchKZG(backend kzgBackend, blobs []kzg4844.Blob, concurrency int) (float64, float64, error) {
+	// The trusted setup is loaded by the first call, which should not be measured
+	if _, err := backend.BlobToCommitment(blobs[0]); err != nil {
+		return 0, 0, err
+	}
+
+	commits := make([]kzg4844.Commitment, len(blobs))
+	start := time.Now()
+	err := parallel(len(blobs), concurrency, func(i int) error {
+		var err error
+		commits[i], err = backend.BlobToCommitment(blobs[i])
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	commitTime := time.Since(start)
+
+	start = time.Now()
+	err = parallel(len(blobs), concurrency, func(i int) error {
+		_, err := backend.ComputeBlobProof(blobs[i], commits[i])
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	proofTime := time.Since(start)
+
+	return float64(len(blobs)) / commitTime.Seconds(), float64(len(blobs)) / proofTime.Seconds(), nil
+}
+
+func BenchApp(cliCtx *cli.Context) error {
+	numBlobs := cliCtx.Int(BenchBlobsFlag.Name)
+	concurrency := cliCtx.Int(TxConcurrencyFlag.Name)
+	if numBlobs < 1 {
+		return fmt.Errorf("invalid number of blobs %d", numBlobs)
+	}
+	setup, err := readTrustedSetupFlag(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	blobs := randomBlobs(numBlobs)
+	fmt.Printf("%d blobs, %d workers\n", numBlobs, kzgConcurrency(concurrency))
+	fmt.Printf("%-8s %14s %14s\n", "backend", "commitments/s", "proofs/s")
+	for _, name := range kzgBackendNames {
+		backend, err := newKZGBackend(name, setup)
+		if err != nil {
+			fmt.Printf("%-8s unavailable: %v\n", name, err)
+			continue
+		}
+		commitments, proofs, err := benchKZG(backend, blobs, concurrency)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("%-8s %14.1f %14.1f\n", name, commitments, proofs)
+	}
+	return nil
+}
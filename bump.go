@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/holiman/uint256"
+	"github.com/urfave/cli"
+)
+
+// maxBumpAttempts is how many times the fees of a transaction are raised when the node answers that a transaction
+// with the same nonce pays more
+const maxBumpAttempts = 5
+
+// Messages of the transaction pool errors of geth. Errors only come as text over JSON-RPC, and importing core or
+// core/txpool for them would pull the whole database stack of geth into the binary.
+const (
+	errAlreadyKnownMessage       = "already known"
+	errReplaceUnderpricedMessage = "replacement transaction underpriced"
+	errNonceTooLowMessage        = "nonce too low"
+)
+
+// isAlreadyKnown tells if a node rejected a transaction because it already has it
+func isAlreadyKnown(err error) bool {
+	return strings.Contains(err.Error(), errAlreadyKnownMessage)
+}
+
+// isReplacementUnderpriced tells if a node rejected a transaction because one with the same nonce pays more
+func isReplacementUnderpriced(err error) bool {
+	return strings.Contains(err.Error(), errReplaceUnderpricedMessage)
+}
+
+// bumpFee raises fee by percent, rounding up, and by at least 1 wei
+func bumpFee(fee *uint256.Int, percent uint64) *uint256.Int {
+	bumped := new(uint256.Int).Mul(fee, uint256.NewInt(100+percent))
+	bumped.Add(bumped, uint256.NewInt(99))
+	bumped.Div(bumped, uint256.NewInt(100))
+	if bumped.Cmp(fee) <= 0 {
+		bumped.AddUint64(fee, 1)
+	}
+	return bumped
+}
+
+// bumpBlobTxFees raises the tip, fee cap and blob fee cap of blobTx by percent. Nodes only replace a transaction
+// if all of them are raised: by 10% in most pools, and by 100% in the blob pool of geth.
+func bumpBlobTxFees(blobTx *types.BlobTx, percent uint64) {
+	blobTx.GasTipCap = bumpFee(blobTx.GasTipCap, percent)
+	blobTx.GasFeeCap = bumpFee(blobTx.GasFeeCap, percent)
+	blobTx.BlobFeeCap = bumpFee(blobTx.BlobFeeCap, percent)
+}
+
+// sendBlobTxBumping sends blobTx, raising its fees by bumpPercent while another transaction with the same nonce
+// pays more
+func sendBlobTxBumping(ctx context.Context, client *ethclient.Client, signer txSigner, chainId *big.Int, blobTx *types.BlobTx, bumpPercent uint64) (*types.Transaction, error) {
+	for attempt := 1; ; attempt++ {
+		tx, err := sendBlobTx(ctx, client, signer, chainId, blobTx)
+		if err == nil || !isReplacementUnderpriced(err) || attempt == maxBumpAttempts {
+			return tx, err
+		}
+		log.Printf("Nonce %d is taken by a transaction paying more, raising the fees by %d%%", blobTx.Nonce, bumpPercent)
+		bumpBlobTxFees(blobTx, bumpPercent)
+	}
+}
+
+// replaceBlobTx sends tx again with the same nonce and blobs, and fees raised by bumpPercent. The blob fee cap is
+// raised to the current blob base fee times headroom if that is higher.
+func replaceBlobTx(ctx context.Context, client *ethclient.Client, signer txSigner, tx *types.Transaction, bumpPercent uint64, headroom float64) (*types.Transaction, error) {
+	if tx.Type() != types.BlobTxType || tx.BlobTxSidecar() == nil {
+		return nil, errors.New("only blob transactions with their blobs can be replaced")
+	}
+	if tx.To() == nil {
+		return nil, errors.New("blob transaction without recipient")
+	}
+	blobTx := &types.BlobTx{
+		ChainID:    uint256.MustFromBig(tx.ChainId()),
+		Nonce:      tx.Nonce(),
+		GasTipCap:  uint256.MustFromBig(tx.GasTipCap()),
+		GasFeeCap:  uint256.MustFromBig(tx.GasFeeCap()),
+		Gas:        tx.Gas(),
+		To:         *tx.To(),
+		Value:      uint256.MustFromBig(tx.Value()),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+		BlobFeeCap: uint256.MustFromBig(tx.BlobGasFeeCap()),
+		BlobHashes: tx.BlobHashes(),
+		Sidecar:    tx.BlobTxSidecar(),
+	}
+	bumpBlobTxFees(blobTx, bumpPercent)
+	if headroom >= 1 {
+		if fee, err := blobBaseFee(ctx, client); err == nil {
+			if floor, overflow := uint256.FromBig(applyHeadroom(fee, headroom)); !overflow && floor.Cmp(blobTx.BlobFeeCap) > 0 {
+				blobTx.BlobFeeCap = floor
+			}
+		}
+	}
+
+	log.Printf("Replacing transaction %v (nonce %d): GasTipCap=%v GasFeeCap=%v BlobFeeCap=%v", tx.Hash(), tx.Nonce(), blobTx.GasTipCap, blobTx.GasFeeCap, blobTx.BlobFeeCap)
+	replacement, err := sendBlobTxBumping(ctx, client, signer, tx.ChainId(), blobTx, bumpPercent)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Transaction %v replaced by %v", tx.Hash(), replacement.Hash())
+	return replacement, nil
+}
+
+func BumpApp(cliCtx *cli.Context) error {
+	addr := cliCtx.String(TxRPCURLFlag.Name)
+	hash := common.HexToHash(cliCtx.String(BumpTxFlag.Name))
+	bumpPercent := cliCtx.Uint64(TxBumpPercentFlag.Name)
+	headroom := cliCtx.Float64(TxBlobFeeHeadroomFlag.Name)
+
+	ctx := context.Background()
+	client, err := ethclient.DialContext(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	signer, err := readSigner(ctx, cliCtx)
+	if err != nil {
+		return err
+	}
+
+	if receipt, err := client.TransactionReceipt(ctx, hash); err == nil {
+		return fmt.Errorf("transaction %v is already included in block %v", hash, receipt.BlockNumber)
+	}
+
+	// Pending blob transactions are returned with their blobs, which the replacement needs
+	var raw hexutil.Bytes
+	if err := client.Client().CallContext(ctx, &raw, "eth_getRawTransactionByHash", hash); err != nil {
+		return fmt.Errorf("error getting transaction %v: %w", hash, err)
+	}
+	if len(raw) == 0 {
+		return fmt.Errorf("transaction %v not found", hash)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return fmt.Errorf("error decoding transaction %v: %w", hash, err)
+	}
+	if tx.Type() == types.BlobTxType && tx.BlobTxSidecar() == nil {
+		return fmt.Errorf("the node returned transaction %v without its blobs, it is not pending in its blob pool", hash)
+	}
+	sender, err := types.Sender(types.NewCancunSigner(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	if sender != signer.Address() {
+		return fmt.Errorf("transaction %v was sent by %v, not by the signing account %v", hash, sender, signer.Address())
+	}
+
+	replacement, err := replaceBlobTx(ctx, client, signer, tx, bumpPercent, headroom)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replacement transaction: %v\n", replacement.Hash())
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+)
+
+func TestBumpFee(t *testing.T) {
+	tests := []struct {
+		fee, percent, want uint64
+	}{
+		{100, 10, 110},
+		{100, 100, 200},
+		{7, 10, 8},  // 7.7 is rounded up
+		{1, 10, 2},  // raised by at least 1 wei
+		{0, 100, 1}, // a zero fee is raised too
+		{5, 0, 6},   // even without percent
+		{30, 100, 60},
+	}
+	for _, tt := range tests {
+		got := bumpFee(uint256.NewInt(tt.fee), tt.percent)
+		if got.Uint64() != tt.want {
+			t.Errorf("bumpFee(%d, %d) = %v, want %d", tt.fee, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestBumpBlobTxFees(t *testing.T) {
+	blobTx := &types.BlobTx{
+		GasTipCap:  uint256.NewInt(1000),
+		GasFeeCap:  uint256.NewInt(5000),
+		BlobFeeCap: uint256.NewInt(300),
+	}
+	tip := blobTx.GasTipCap
+	bumpBlobTxFees(blobTx, 100)
+	if blobTx.GasTipCap.Uint64() != 2000 || blobTx.GasFeeCap.Uint64() != 10000 || blobTx.BlobFeeCap.Uint64() != 600 {
+		t.Fatalf("unexpected fees %v %v %v", blobTx.GasTipCap, blobTx.GasFeeCap, blobTx.BlobFeeCap)
+	}
+	if tip.Uint64() != 1000 {
+		t.Fatal("bumping modified the previous fee")
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	if !isAlreadyKnown(errors.New("already known")) {
+		t.Error("already known not recognized")
+	}
+	if !isReplacementUnderpriced(errors.New("replacement transaction underpriced")) {
+		t.Error("replacement underpriced not recognized")
+	}
+	if isAlreadyKnown(errors.New("nonce too low")) || isReplacementUnderpriced(errors.New("nonce too low")) {
+		t.Error("unrelated error recognized")
+	}
+}
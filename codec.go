@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Codec is a format used to store data in blobs. The download path probes every blob it retrieves against the
+// registered codecs, so new formats only need to be registered with RegisterCodec.
+type Codec interface {
+	// Name identifies the codec on the command line
+	Name() string
+	// Encode splits data in blobs
+	Encode(data []byte, opts EncodeOptions) ([]kzg4844.Blob, error)
+	// Decode returns the header and the payload of a blob. Formats without a header describe each blob as a
+	// file made of a single blob.
+	Decode(blob []byte) (*MagicHeader, []byte, error)
+	// Detect reports whether the blob looks like it was encoded with this codec
+	Detect(blob []byte) bool
+}
+
+// BatchCodec is implemented by codecs of blobs posted by rollups. These blobs are not parts of a file, so they are
+// not auto-detected by GetMultiPartBlob: download writes the payload of every matching blob of the slot instead.
+type BatchCodec interface {
+	Codec
+	// WriteBatch saves the payload of the blob at index in slot
+	WriteBatch(slot, index int, payload []byte) error
+}
+
+// codecs are probed in order, so more specific formats must be registered first
+var codecs []Codec
+
+func RegisterCodec(codec Codec) {
+	if _, err := lookupCodec(codec.Name()); err == nil {
+		panic(fmt.Sprintf("codec %s registered twice", codec.Name()))
+	}
+	codecs = append(codecs, codec)
+}
+
+func init() {
+	RegisterCodec(magicCodec{})
+	RegisterCodec(rawCodec{})
+	RegisterCodec(opStackCodec{})
+}
+
+func codecNames() string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func lookupCodec(name string) (Codec, error) {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q, available codecs: %s", name, codecNames())
+}
+
+// fileCodecs returns the codecs probed by GetMultiPartBlob by default
+func fileCodecs() []Codec {
+	var files []Codec
+	for _, codec := range codecs {
+		if _, ok := codec.(BatchCodec); !ok {
+			files = append(files, codec)
+		}
+	}
+	return files
+}
+
+// detectCodec returns the first of the candidates that recognizes the blob, or nil
+func detectCodec(blob []byte, candidates []Codec) Codec {
+	for _, codec := range candidates {
+		if codec.Detect(blob) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// magicCodec is used by multipart uploads: every blob starts with a magic header (see generateMagicHeader)
+type magicCodec struct{}
+
+func (magicCodec) Name() string { return "multipart" }
+
+func (magicCodec) Encode(data []byte, opts EncodeOptions) ([]kzg4844.Blob, error) {
+	return encodeBlobsWithMagicHeader(data, opts)
+}
+
+func (magicCodec) Decode(blob []byte) (*MagicHeader, []byte, error) {
+	return decodeMagicBlob(blob)
+}
+
+func (magicCodec) Detect(blob []byte) bool {
+	return len(blob) == params.BlobTxFieldElementsPerBlob*32 && hasMagicHeader(blob)
+}
+
+// rawCodec is used by single transaction uploads (see encodeBlobs). The blobs have no seed or index, so each one
+// is decoded as a file on its own.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "raw" }
+
+func (rawCodec) Encode(data []byte, opts EncodeOptions) ([]kzg4844.Blob, error) {
+	return encodeBlobs(data, opts), nil
+}
+
+func (rawCodec) Decode(blob []byte) (*MagicHeader, []byte, error) {
+	return decodeRawBlob(blob)
+}
+
+// Detect checks the first field element is a valid header and that the payload is followed by zeros. Unversioned
+// blobs can hold anything, so they are not detected.
+func (rawCodec) Detect(blob []byte) bool {
+	if len(blob) != params.BlobTxFieldElementsPerBlob*32 {
+		return false
+	}
+	flags, length, ok := rawBlobHeader(blob)
+	if !ok {
+		return false
+	}
+	for _, b := range decodePayload(blob, 1, flags)[length:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+func TestCodecDetection(t *testing.T) {
+	for _, opts := range encodeOptionsTests {
+		for _, codec := range fileCodecs() {
+			blobs, err := codec.Encode(makeBlob(1000), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, blob := range blobs {
+				if detected := detectCodec(blob[:], codecs); detected != codec {
+					t.Fatalf("%s blob %d (%+v) detected as %v", codec.Name(), i, opts, detected)
+				}
+				if _, _, err := codec.Decode(blob[:]); err != nil {
+					t.Fatalf("%s blob %d (%+v): %v", codec.Name(), i, opts, err)
+				}
+			}
+		}
+	}
+
+	var empty kzg4844.Blob
+	if detected := detectCodec(empty[:], codecs); detected != nil {
+		t.Fatalf("empty blob detected as %s", detected.Name())
+	}
+	if _, err := lookupCodec("unknown"); err == nil {
+		t.Fatal("expected error looking up unknown codec")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm used to compress a file before splitting it in blobs. It is stored in the magic header.
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(c))
+}
+
+func parseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unknown compression algorithm %q (none, gzip or zstd)", name)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor returns a writer that compresses data to w. It must be closed to flush the compressed data.
+func newCompressor(w io.Writer, algorithm Compression) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %v", algorithm)
+}
+
+func newDecompressionReader(r io.Reader, algorithm Compression) (io.Reader, error) {
+	switch algorithm {
+	case CompressionNone:
+		return r, nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %v", algorithm)
+}
+
+// decompressor decompresses the chunks written to it as they arrive and passes the result to out
+type decompressor struct {
+	pipe      *io.PipeWriter
+	done      chan error
+	closeOnce sync.Once
+	err       error
+}
+
+func newDecompressor(algorithm Compression, out func([]byte) error) *decompressor {
+	pr, pw := io.Pipe()
+	d := &decompressor{pipe: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := func() error {
+			r, err := newDecompressionReader(pr, algorithm)
+			if err != nil {
+				return err
+			}
+			buf := make([]byte, 128*1024)
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					if err := out(common.CopyBytes(buf[:n])); err != nil {
+						return err
+					}
+				}
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("error decompressing file: %w", err)
+				}
+			}
+		}()
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *decompressor) Write(chunk []byte) error {
+	_, err := d.pipe.Write(chunk)
+	return err
+}
+
+// Close waits until all the data has been decompressed
+func (d *decompressor) Close() error {
+	d.closeOnce.Do(func() {
+		d.pipe.Close()
+		d.err = <-d.done
+	})
+	return d.err
+}
+
+// Abort stops decompressing without waiting for the rest of the data
+func (d *decompressor) Abort() {
+	d.pipe.CloseWithError(errors.New("decompression aborted"))
+	d.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("test_files/whitepaper.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		_, compressed := storedFile(t, data, EncodeOptions{Compression: algorithm})
+		if algorithm != CompressionNone && len(compressed) >= len(data) {
+			t.Fatalf("%v: expected compressed size lower than %d, got %d", algorithm, len(data), len(compressed))
+		}
+
+		var dec []byte
+		d := newDecompressor(algorithm, func(b []byte) error {
+			dec = append(dec, b...)
+			return nil
+		})
+		// Feed the data in chunks as GetMultiPartBlob does
+		for start := 0; start < len(compressed); start += 1000 {
+			end := start + 1000
+			if end > len(compressed) {
+				end = len(compressed)
+			}
+			if err := d.Write(compressed[start:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, dec) {
+			t.Fatalf("%v: round trip failed: expected %d bytes, got %d", algorithm, len(data), len(dec))
+		}
+	}
+}
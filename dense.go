@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// The dense codec stores 254 bits in every field element instead of 31 bytes. 2^254 is lower than the
+// BLS12-381 scalar modulus, so a field element with its two most significant bits unset is always canonical.
+//
+// The payload is treated as a stream of bits (most significant bit first) that fills the lower 254 bits of
+// one field element after the other.
+const denseBitsPerFieldElement = 254
+
+// denseCapacity returns the number of bytes that fit in `fieldElements` field elements using the dense codec
+func denseCapacity(fieldElements int) int {
+	return fieldElements * denseBitsPerFieldElement / 8
+}
+
+// denseBitPosition returns the position in the blob (in bits) of the nth bit of the payload,
+// when the payload starts at field element `offset`
+func denseBitPosition(offset, n int) int {
+	return (offset+n/denseBitsPerFieldElement)*256 + 256 - denseBitsPerFieldElement + n%denseBitsPerFieldElement
+}
+
+// encodeDenseFieldElements packs data in the blob 254 bits at a time, starting at field element `offset`.
+// The blob is expected to be zeroed from `offset` onwards.
+func encodeDenseFieldElements(blob *kzg4844.Blob, offset int, data []byte) {
+	for i, b := range data {
+		n := i * 8
+		pos := denseBitPosition(offset, n)
+
+		if n%denseBitsPerFieldElement+8 <= denseBitsPerFieldElement {
+			// Fast path, the whole byte goes to the same field element
+			shift := pos % 8
+			blob[pos/8] |= b >> shift
+			if shift > 0 {
+				blob[pos/8+1] |= b << (8 - shift)
+			}
+			continue
+		}
+
+		// The byte is split between two field elements
+		for bit := 0; bit < 8; bit++ {
+			if b&(0x80>>bit) != 0 {
+				pos = denseBitPosition(offset, n+bit)
+				blob[pos/8] |= 0x80 >> (pos % 8)
+			}
+		}
+	}
+}
+
+// decodeDenseFieldElements is the inverse of encodeDenseFieldElements. It returns all the bytes that fit
+// from field element `offset` until the end of the blob.
+func decodeDenseFieldElements(blob []byte, offset int) []byte {
+	data := make([]byte, denseCapacity(params.BlobTxFieldElementsPerBlob-offset))
+	for i := range data {
+		n := i * 8
+		pos := denseBitPosition(offset, n)
+
+		if n%denseBitsPerFieldElement+8 <= denseBitsPerFieldElement {
+			shift := pos % 8
+			b := blob[pos/8] << shift
+			if shift > 0 {
+				b |= blob[pos/8+1] >> (8 - shift)
+			}
+			data[i] = b
+			continue
+		}
+
+		var b byte
+		for bit := 0; bit < 8; bit++ {
+			pos = denseBitPosition(offset, n+bit)
+			if blob[pos/8]&(0x80>>(pos%8)) != 0 {
+				b |= 0x80 >> bit
+			}
+		}
+		data[i] = b
+	}
+	return data
+}
@@ -2,20 +2,31 @@ package main
 
 import (
 	"bytes"
-	"encoding/hex"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/urfave/cli"
 )
 
 type BlobResponse struct {
-	Data []struct {
-		Blob string `json:"blob"`
-	} `json:"data"`
+	Data []BlobSidecar `json:"data"`
+}
+
+// BlobSidecar is a blob sidecar returned by the beacon node
+type BlobSidecar struct {
+	Index         string `json:"index"`
+	Blob          string `json:"blob"`
+	KZGCommitment string `json:"kzg_commitment"`
+	KZGProof      string `json:"kzg_proof"`
 }
 
 func appendToFile(filename string, data []byte) error {
@@ -33,119 +44,552 @@ func appendToFile(filename string, data []byte) error {
 	return nil
 }
 
-func GetMultiPartBlob(blobChannel chan<- []byte, addr string, initialSlot int, saveFiles bool) error {
+type DownloadOptions struct {
+	// SaveFiles writes the file to <slot>.blob
+	SaveFiles bool
+	// Identity is the X25519 private key used to decrypt encrypted files
+	Identity []byte
+	// OnManifest is called with the manifest of the file before any chunk is sent
+	OnManifest func(*Manifest)
+	// Codec restricts the download to blobs of one of the registered codecs. All of them are probed if empty.
+	Codec string
+	// Path only downloads one file of a directory upload. When the archive is neither compressed nor encrypted,
+	// only the chunks that hold the file are verified and written, and the download stops after the last one.
+	// The slots before the first of them are still fetched, their number of blobs is not known in advance.
+	Path string
+	// OutputDir is where SaveFiles extracts directory uploads, <slot> by default
+	OutputDir string
+	// Context stops the download when it is done, e.g. when the client of /stream disconnects. context.Background() if nil.
+	Context context.Context
+}
+
+// downloadMaxEmptySlots is the number of consecutive slots without blobs of the file after which we give up
+const downloadMaxEmptySlots = 64
+
+// GetMultiPartBlob sends the chunks of the file starting at initialSlot through blobChannel, which is closed when it returns.
+// If the file has a manifest, chunks are verified before being sent and an error is returned on mismatch.
+// Erasure coded files are rebuilt as soon as enough blobs have been retrieved, even if some are missing.
+// Encrypted files are decrypted with opts.Identity and compressed files are decompressed before being sent.
+// Directory uploads are sent as an archive, or only the file at opts.Path.
+func GetMultiPartBlob(blobChannel chan<- []byte, addr string, initialSlot int, opts DownloadOptions) error {
+	defer close(blobChannel)
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
-	var blobIndex, totalBlobs int
-	magicHeaderCustom := make([]byte, 8)
+	candidates := fileCodecs()
+	if opts.Codec != "" {
+		codec, err := lookupCodec(opts.Codec)
+		if err != nil {
+			return err
+		}
+		if _, ok := codec.(BatchCodec); ok {
+			return fmt.Errorf("%s blobs are not files", codec.Name())
+		}
+		candidates = []Codec{codec}
+	}
+
+	var (
+		file        *MagicHeader // header of the first blob of the file
+		fileCodec   Codec
+		erasure     *erasureDecoder
+		verifier    *manifestVerifier
+		decompress  *decompressor
+		decrypt     *decryptor
+		output      func([]byte) error // receives the chunks of the file once verified
+		manifest    *Manifest
+		extractor   *archiveExtractor
+		filter      func([]byte) error // only passes the file at opts.Path
+		partial     bool               // only chunks firstChunk to lastChunk are needed
+		firstChunk  int
+		lastChunk   int
+		partialDone bool
+		received    = make(map[int]bool)
+		chunkIndex  int // index of the next chunk of the file
+		emptySlots  int
+	)
+	defer func() {
+		if decompress != nil {
+			decompress.Abort()
+		}
+	}()
 
 	slot := initialSlot
 	filename := fmt.Sprintf("%d.blob", initialSlot)
 
+	send := func(data []byte) error {
+		select {
+		case blobChannel <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if opts.SaveFiles && extractor != nil {
+			return extractor.Write(data)
+		}
+		if opts.SaveFiles {
+			err := appendToFile(filename, data)
+			if err != nil {
+				fmt.Println("Error appending to file:", err)
+				return err
+			}
+			fmt.Printf("Blob content written to '%s' successfully.\n", filename)
+		}
+		return nil
+	}
+
+	// emit sends the content of the file as it is rebuilt
+	emit := func(data []byte) error {
+		if filter != nil {
+			return filter(data)
+		}
+		return send(data)
+	}
+
+	// handleArchive prepares the extraction of a directory upload
+	handleArchive := func() error {
+		if manifest.Archive == nil {
+			if opts.Path != "" {
+				return fmt.Errorf("file is not a directory upload, can not extract %s", opts.Path)
+			}
+			return nil
+		}
+		fmt.Printf("Directory upload with %d files\n", len(manifest.Archive))
+
+		entries := manifest.Archive
+		if opts.Path != "" {
+			entry, err := findArchiveEntry(manifest.Archive, opts.Path)
+			if err != nil {
+				return err
+			}
+			entries = []ArchiveEntry{entry}
+
+			// Archives stored as is can be read from any chunk
+			var start uint64
+			if manifest.Encryption == nil && file.Compression == CompressionNone && manifest.ChunkSize > 0 {
+				chunkSize := uint64(manifest.ChunkSize)
+				partial = true
+				firstChunk = int(entry.Offset / chunkSize)
+				lastChunk = firstChunk - 1
+				if entry.Size > 0 {
+					lastChunk = int((entry.Offset + entry.Size - 1) / chunkSize)
+				}
+				partialDone = lastChunk < firstChunk
+				start = uint64(firstChunk) * chunkSize
+				// Chunks are verified one by one instead
+				verifier = nil
+				fmt.Printf("%s is in chunks %d to %d\n", entry.Path, firstChunk, lastChunk)
+			}
+			filter = archiveEntryFilter(entry, start, send)
+		}
+
+		if opts.SaveFiles {
+			dir := opts.OutputDir
+			if dir == "" {
+				dir = strconv.Itoa(initialSlot)
+			}
+			var err error
+			if extractor, err = newArchiveExtractor(dir, entries, entries[0].Offset); err != nil {
+				return err
+			}
+			fmt.Printf("Extracting to '%s'\n", dir)
+		}
+		return nil
+	}
+
+	// handleMetadata passes the manifest on once its name and archive index are known
+	handleMetadata := func() error {
+		if manifest.Filename != "" || manifest.ContentType != "" {
+			fmt.Printf("Original file: %s (%s)\n", manifest.Filename, manifest.ContentType)
+		}
+		if opts.OnManifest != nil {
+			opts.OnManifest(manifest)
+		}
+		return handleArchive()
+	}
+
+	// handleManifest prepares the download once the manifest and all its chunk digests are read
+	handleManifest := func() error {
+		verifier = newManifestVerifier(manifest)
+		if manifest.Encryption == nil {
+			return handleMetadata()
+		}
+
+		if opts.Identity == nil {
+			return errors.New("file is encrypted, an identity is required to download it")
+		}
+		contentKey, err := unwrapKey(manifest.Encryption, opts.Identity)
+		if err != nil {
+			return err
+		}
+		plaintext := output
+		if manifest.Encryption.Metadata {
+			// The metadata is at the start of the encrypted stream, it is only known once the first chunk is decrypted
+			plaintext = readFileMetadata(func(metadata fileMetadata) error {
+				manifest.Filename, manifest.ContentType, manifest.Archive = metadata.Filename, metadata.ContentType, metadata.Archive
+				return handleMetadata()
+			}, output)
+		}
+		decrypt, err = newDecryptor(contentKey, plaintext)
+		if err != nil {
+			return err
+		}
+		output = decrypt.Write
+		fmt.Println("File is encrypted, decrypting with identity")
+		if !manifest.Encryption.Metadata {
+			return handleMetadata()
+		}
+		return nil
+	}
+
+	// handlePayload processes the payloads of the file (manifest, chunk digests or chunks) in order
+	handlePayload := func(payload []byte, isManifest bool) error {
+		switch {
+		case manifest != nil && !manifest.complete():
+			// The chunk digests of big files follow the manifest
+			if err := manifest.addDigests(payload); err != nil {
+				return err
+			}
+			if !manifest.complete() {
+				return nil
+			}
+			return handleManifest()
+
+		case isManifest:
+			var err error
+			manifest, err = decodeManifest(payload)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Manifest: %d bytes in %d chunks, sha256=%x\n", manifest.FileSize, manifest.Chunks, manifest.SHA256)
+			if !manifest.complete() {
+				fmt.Printf("Chunk digests are stored in the next %d blobs\n", manifest.DigestBlobs)
+				return nil
+			}
+			return handleManifest()
+		}
+
+		chunk := chunkIndex
+		chunkIndex++
+		if partial {
+			if chunk < firstChunk || chunk > lastChunk {
+				return nil
+			}
+			if digest := sha256.Sum256(payload); common.Hash(digest) != manifest.ChunkDigests[chunk] {
+				return fmt.Errorf("integrity check failed: chunk %d has digest %x, expected %x", chunk, digest, manifest.ChunkDigests[chunk])
+			}
+			partialDone = chunk == lastChunk
+		}
+		if verifier != nil {
+			if err := verifier.Write(payload); err != nil {
+				return err
+			}
+		}
+
+		if file.Flags&headerFlagEncrypted != 0 && decrypt == nil {
+			return errors.New("file is encrypted but the manifest has no keys")
+		}
+		return output(payload)
+	}
+
 	for {
 		//fmt.Printf("Retrieving multi-part blob from slot %d\n", slot)
 		apiURL := fmt.Sprintf("%s/eth/v1/beacon/blob_sidecars/%d", addr, slot)
 
-		resp, err := http.Get(apiURL)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			fmt.Println("Error making HTTP request:", err)
 			return err
 		}
-		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
 			fmt.Println("Received non-OK status code:", resp.StatusCode)
+			emptySlots++
+			if emptySlots > downloadMaxEmptySlots {
+				return fmt.Errorf("no blobs found in the last %d slots, only %d of %d blobs were retrieved", downloadMaxEmptySlots, len(received), totalBlobs(file))
+			}
 			slot++
 			continue
 		}
 
 		var responseObject BlobResponse
 		err = json.NewDecoder(resp.Body).Decode(&responseObject)
+		resp.Body.Close()
 		if err != nil {
 			fmt.Println("Error decoding JSON:", err)
 			return err
 		}
 
+		emptySlots++
 		for _, item := range responseObject.Data {
 			// fmt.Println("Retrieving blob index", idx)
-			blobValue := item.Blob
+			hexBytes, err := hexutil.Decode(item.Blob)
+			if err != nil {
+				fmt.Println("Error decoding hex string:", err)
+				return err
+			}
 
-			if blobValue[0:30] != "0x426c6f6273417265436f6d696e67" {
-				//fmt.Println("Blob number", idx, "does not contain magic header:", blobValue[0:30])
+			codec := detectCodec(hexBytes, candidates)
+			if codec == nil || (fileCodec != nil && codec != fileCodec) {
 				continue
 			}
 
-			hexBytes, err := hex.DecodeString(blobValue[2:])
+			header, payload, err := codec.Decode(hexBytes)
 			if err != nil {
-				fmt.Println("Error decoding hex string:", err)
-				return err
+				fmt.Printf("Skipping %s blob: %v\n", codec.Name(), err)
+				continue
 			}
-
-			blobIndex = int(hexBytes[17])
+			blobIndex := header.BlobIndex
 
 			//fmt.Printf("Magic header: %v\n", hexBytes[0:32])
 			//fmt.Printf("FULL BLOB:\n%v\n", hexBytes)
 
-			if blobIndex == 0 {
-				totalBlobs = int(hexBytes[19])
-				copy(magicHeaderCustom, hexBytes[24:32])
-			} else {
-				if !bytes.Equal(magicHeaderCustom, hexBytes[24:32]) {
-					// SKIP
-					fmt.Println("Found blob with magic header but skipping because seed does not match.")
+			if file == nil {
+				// Erasure coded files can be retrieved even if the first blob is missing
+				if blobIndex != 0 && header.Flags&headerFlagErasure == 0 {
+					fmt.Println("Found blob with magic header but skipping because it is not the first blob of a file.")
 					continue
 				}
+				file = header
+				fileCodec = codec
+				fmt.Printf("Found %s blob\n", codec.Name())
+				if header.Flags&headerFlagEncrypted != 0 && opts.Identity == nil {
+					return errors.New("file is encrypted, an identity is required to download it")
+				}
+				output = emit
+				if header.Compression != CompressionNone {
+					fmt.Println("File is compressed with", header.Compression)
+					decompress = newDecompressor(header.Compression, emit)
+					output = decompress.Write
+				}
+				if header.Flags&headerFlagErasure != 0 {
+					erasure, err = newErasureDecoder(header.Erasure, header.Length)
+					if err != nil {
+						return err
+					}
+				}
+			} else if !bytes.Equal(file.Seed, header.Seed) {
+				// SKIP
+				fmt.Println("Found blob with magic header but skipping because seed does not match.")
+				continue
 			}
+			if received[blobIndex] {
+				continue
+			}
+			received[blobIndex] = true
+			emptySlots = 0
 
-			cleanHexBytes := DecodeMagicBlob(hexBytes)
-
-			fmt.Printf("[SLOT %d] Received blob %d of %d with size=%d\n", slot, blobIndex+1, totalBlobs, len(cleanHexBytes))
-
-			blobChannel <- cleanHexBytes
+			fmt.Printf("[SLOT %d] Received blob %d of %d with size=%d\n", slot, blobIndex+1, file.TotalBlobs, len(payload))
 
-			if saveFiles {
-				err := appendToFile(filename, cleanHexBytes)
-				if err != nil {
-					fmt.Println("Error appending to file:", err)
+			if erasure == nil {
+				if err := handlePayload(payload, header.Flags&headerFlagManifest != 0); err != nil {
 					return err
 				}
-				fmt.Printf("Blob content written to '%s' successfully.\n", filename)
+				if blobIndex+1 != file.TotalBlobs && !partialDone {
+					continue
+				}
+			} else {
+				if err := erasure.Add(blobIndex, payload); err != nil {
+					return err
+				}
+				for {
+					payloads, err := erasure.Next()
+					if err != nil {
+						return err
+					}
+					if payloads == nil {
+						break
+					}
+					for _, payload := range payloads {
+						// The first payload of erasure coded files is always the manifest
+						if err := handlePayload(payload, manifest == nil); err != nil {
+							return err
+						}
+					}
+				}
+				if !erasure.Done() && !partialDone {
+					continue
+				}
+				if missing := file.TotalBlobs - len(received); missing > 0 {
+					fmt.Printf("File rebuilt from erasure coding, %d blobs were not retrieved\n", missing)
+				}
 			}
 
-			if blobIndex+1 == totalBlobs {
-				fmt.Printf("%d blobs were retrieved in total\n", totalBlobs+1)
-				if saveFiles {
-					fmt.Printf("Hex bytes written to '%s' file successfully.\n", filename)
+			fmt.Printf("%d blobs were retrieved in total\n", len(received))
+			if decrypt != nil {
+				if err := decrypt.Close(); err != nil {
+					return err
 				}
-				close(blobChannel)
-				return nil
 			}
+			if decompress != nil {
+				if err := decompress.Close(); err != nil {
+					return err
+				}
+			}
+			if extractor != nil {
+				if err := extractor.Close(); err != nil {
+					return err
+				}
+			}
+			if partial {
+				fmt.Printf("Chunks of %s verified\n", opts.Path)
+			} else if verifier == nil {
+				fmt.Println("File has no manifest, skipping integrity check")
+			} else if err := verifier.Verify(); err != nil {
+				return err
+			} else {
+				fmt.Println("File integrity verified")
+			}
+			if opts.SaveFiles {
+				fmt.Printf("Hex bytes written to '%s' file successfully.\n", filename)
+			}
+			return nil
 		}
 
+		if emptySlots > downloadMaxEmptySlots {
+			return fmt.Errorf("no blobs found in the last %d slots, only %d of %d blobs were retrieved", downloadMaxEmptySlots, len(received), totalBlobs(file))
+		}
 		slot++
 	}
 
 	//fmt.Println("Total blobs in this slot:", len(responseObject.Data))
 }
 
+// totalBlobs returns the number of blobs of the file, or 0 if it is unknown yet
+func totalBlobs(file *MagicHeader) int {
+	if file == nil {
+		return 0
+	}
+	return file.TotalBlobs
+}
+
+// SlotBlob is a blob of a slot decoded by one of the registered codecs
+type SlotBlob struct {
+	Index   int
+	Codec   Codec
+	Payload []byte
+}
+
+// GetSlotBlobs returns the blobs of slot that are detected by one of the candidates
+func GetSlotBlobs(addr string, slot int, candidates []Codec) ([]SlotBlob, error) {
+	apiURL := fmt.Sprintf("%s/eth/v1/beacon/blob_sidecars/%d", addr, slot)
+
+	resp, err := http.Get(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-OK status code: %d", resp.StatusCode)
+	}
+
+	var responseObject BlobResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responseObject); err != nil {
+		return nil, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	var blobs []SlotBlob
+	for i, item := range responseObject.Data {
+		index := i
+		if item.Index != "" {
+			if index, err = strconv.Atoi(item.Index); err != nil {
+				return nil, fmt.Errorf("invalid blob index %q", item.Index)
+			}
+		}
+
+		hexBytes, err := hexutil.Decode(item.Blob)
+		if err != nil {
+			return nil, err
+		}
+		codec := detectCodec(hexBytes, candidates)
+		if codec == nil {
+			continue
+		}
+		_, payload, err := codec.Decode(hexBytes)
+		if err != nil {
+			return nil, fmt.Errorf("blob %d: %w", index, err)
+		}
+		blobs = append(blobs, SlotBlob{Index: index, Codec: codec, Payload: payload})
+	}
+	return blobs, nil
+}
+
+// downloadBatches writes every blob of slot encoded with codec
+func downloadBatches(addr string, slot int, codec BatchCodec) error {
+	blobs, err := GetSlotBlobs(addr, slot, []Codec{codec})
+	if err != nil {
+		return err
+	}
+	if len(blobs) == 0 {
+		return fmt.Errorf("no %s blobs found in slot %d", codec.Name(), slot)
+	}
+	for _, blob := range blobs {
+		if err := codec.WriteBatch(slot, blob.Index, blob.Payload); err != nil {
+			return fmt.Errorf("blob %d: %w", blob.Index, err)
+		}
+	}
+	fmt.Printf("%d %s blobs found in slot %d\n", len(blobs), codec.Name(), slot)
+	return nil
+}
+
 func DownloadApp(cliCtx *cli.Context) error {
 	startTime := time.Now()
 
 	addr := cliCtx.String(DownloadBeaconRPCURLFlag.Name)
 	slot := cliCtx.Int(DownloadSlotFlag.Name)
 
-	blobChannel := make(chan []byte)
-
-	go GetMultiPartBlob(blobChannel, addr, slot, true)
-
-	for {
-		select {
-		case _, ok := <-blobChannel:
-			if !ok {
-				elapsedTime := time.Since(startTime)
-				fmt.Println("Operation took", elapsedTime)
-				return nil
+	if name := cliCtx.String(DownloadCodecFlag.Name); name != "" {
+		codec, err := lookupCodec(name)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		if batchCodec, ok := codec.(BatchCodec); ok {
+			if err := downloadBatches(addr, slot, batchCodec); err != nil {
+				return cli.NewExitError(err, 1)
 			}
+			fmt.Println("Operation took", time.Since(startTime))
+			return nil
 		}
 	}
 
+	var identity []byte
+	if identityFile := cliCtx.String(DownloadIdentityFlag.Name); identityFile != "" {
+		var err error
+		identity, err = readIdentity(identityFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	blobChannel := make(chan []byte)
+	errChannel := make(chan error, 1)
+
+	go func() {
+		errChannel <- GetMultiPartBlob(blobChannel, addr, slot, DownloadOptions{
+			SaveFiles: true,
+			Identity:  identity,
+			Codec:     cliCtx.String(DownloadCodecFlag.Name),
+			Path:      cliCtx.String(DownloadPathFlag.Name),
+			OutputDir: cliCtx.String(DownloadOutputFlag.Name),
+		})
+	}()
+
+	for range blobChannel {
+	}
+
+	if err := <-errChannel; err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	elapsedTime := time.Since(startTime)
+	fmt.Println("Operation took", elapsedTime)
+	return nil
 }
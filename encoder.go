@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+// blobEncoder splits a file read from an io.Reader in blobs with magic headers, one blob at a time.
+//
+// The manifest in the first blob and the total of blobs in every header depend on the whole file, so it is read
+// twice: once to build the manifest and once to encode the chunks. Seekable files stored as is are read in place,
+// anything else (stdin, compressed or encrypted files) is first written to a temporary file in its stored form.
+// Only one chunk is held in memory, or one stripe when the file is erasure coded.
+type blobEncoder struct {
+	opts           EncodeOptions
+	flags          byte
+	capacity       int
+	headerElements int
+	seed           []byte
+	geometry       erasureGeometry
+	totalBlobs     int
+
+	manifest     *Manifest
+	manifestData [][]byte // payloads of the manifest and of its chunk digests
+	source       io.Reader
+	spool        *os.File
+
+	payloadIndex int      // index of the next payload (manifest or chunk) to read
+	pending      [][]byte // payloads read but not encoded yet
+	blobIndex    int
+}
+
+func newBlobEncoder(r io.Reader, opts EncodeOptions) (*blobEncoder, error) {
+	e := &blobEncoder{
+		opts:           opts,
+		flags:          opts.flags(),
+		capacity:       blobCapacity(magicHeaderV2, opts.flags()),
+		headerElements: headerFieldElements(magicHeaderV2),
+		seed:           opts.Seed,
+	}
+	if len(e.seed) == 0 {
+		e.seed = make([]byte, 8)
+		binary.LittleEndian.PutUint64(e.seed, uint64(time.Now().UnixNano()))
+	}
+
+	if opts.ParityShards > 0 {
+		if err := validateErasureOptions(opts.DataShards, opts.ParityShards); err != nil {
+			return nil, err
+		}
+	}
+
+	source, start, encryption, err := e.storedFile(r)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	if err := e.buildManifest(source, encryption); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if _, err := source.Seek(start, io.SeekStart); err != nil {
+		e.Close()
+		return nil, err
+	}
+	e.source = source
+	return e, nil
+}
+
+// storedFile returns the file as it is stored in blobs (compressed and encrypted) and the offset it starts at
+func (e *blobEncoder) storedFile(r io.Reader) (io.ReadSeeker, int64, *Encryption, error) {
+	if e.opts.Compression == CompressionNone && len(e.opts.Recipients) == 0 {
+		if rs, ok := r.(io.ReadSeeker); ok {
+			// Pipes implement io.Seeker but fail to seek
+			if start, err := rs.Seek(0, io.SeekCurrent); err == nil {
+				return rs, start, nil, nil
+			}
+		}
+	}
+
+	spool, err := os.CreateTemp("", "blobtoss-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	e.spool = spool
+
+	var (
+		out        io.WriteCloser = nopWriteCloser{spool}
+		encryption *Encryption
+	)
+	if len(e.opts.Recipients) > 0 {
+		var contentKey []byte
+		contentKey, encryption, err = newEncryption(e.opts.Recipients)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if out, err = newEncryptor(contentKey, spool); err != nil {
+			return nil, 0, nil, err
+		}
+		// The name and index of the file are encrypted too, so they are left out of the manifest
+		encryption.Metadata = true
+		metadata := fileMetadata{Filename: e.opts.Filename, ContentType: e.opts.ContentType, Archive: e.opts.Archive}
+		if err := writeFileMetadata(out, metadata); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	compressed := &countingWriter{w: out}
+	compressor, err := newCompressor(compressed, e.opts.Compression)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	size, err := io.Copy(compressor, r)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("error reading blob file: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, 0, nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, 0, nil, err
+	}
+
+	if e.opts.Compression != CompressionNone {
+		fmt.Printf("Compressed with %v from %d to %d bytes (ratio %.2f). %d blobs saved\n", e.opts.Compression, size, compressed.n,
+			float64(size)/float64(compressed.n), countBlobs(int(size), e.opts)-countBlobs(int(compressed.n), e.opts))
+	}
+	if encryption != nil {
+		fmt.Printf("File encrypted for %d recipients\n", len(e.opts.Recipients))
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, nil, err
+	}
+	return spool, 0, encryption, nil
+}
+
+func (e *blobEncoder) buildManifest(source io.Reader, encryption *Encryption) error {
+	chunkSize := chunkCapacity(e.opts)
+	manifest, err := readManifest(source, chunkSize)
+	if err != nil {
+		return fmt.Errorf("error reading blob file: %w", err)
+	}
+	manifest.Encryption = encryption
+	if encryption == nil {
+		manifest.Filename = e.opts.Filename
+		manifest.ContentType = e.opts.ContentType
+		manifest.Archive = e.opts.Archive
+	}
+	fmt.Printf("File size is %d bytes and will be split into %d blobs of %d bytes\n", manifest.FileSize, manifest.Chunks, chunkSize)
+
+	data, err := encodeManifest(manifest, chunkSize)
+	if err != nil {
+		return err
+	}
+	if len(data) > 1 {
+		fmt.Printf("Chunk digests are stored in %d blobs after the manifest\n", len(data)-1)
+	}
+	e.manifest, e.manifestData = manifest, data
+
+	e.totalBlobs = len(data) + manifest.Chunks
+	if e.opts.ParityShards > 0 {
+		e.geometry = erasureGeometry{
+			DataShards:   e.opts.DataShards,
+			ParityShards: e.opts.ParityShards,
+			DataBlobs:    e.totalBlobs,
+		}
+		e.totalBlobs = e.geometry.totalBlobs()
+		fmt.Printf("Erasure coding adds %d parity blobs, any %d of every %d blobs are enough to rebuild the file\n",
+			e.totalBlobs-e.geometry.DataBlobs, e.geometry.DataShards, e.geometry.DataShards+e.geometry.ParityShards)
+	}
+	if uint64(e.totalBlobs) > math.MaxUint32 {
+		return fmt.Errorf("file is too big to be split in blobs")
+	}
+	return nil
+}
+
+// TotalBlobs returns the number of blobs of the file, parity blobs included
+func (e *blobEncoder) TotalBlobs() int {
+	return e.totalBlobs
+}
+
+// Next returns the next blob of the file, or io.EOF once all of them have been returned
+func (e *blobEncoder) Next() (*kzg4844.Blob, error) {
+	if e.blobIndex == e.totalBlobs {
+		return nil, io.EOF
+	}
+	if len(e.pending) == 0 {
+		if err := e.readPayloads(); err != nil {
+			return nil, err
+		}
+	}
+	payload := e.pending[0]
+	e.pending = e.pending[1:]
+
+	// Blobs of erasure coded files hold shards instead of payloads, only the first one is flagged
+	flags := e.flags
+	if e.blobIndex == 0 || (e.opts.ParityShards == 0 && e.blobIndex < len(e.manifestData)) {
+		flags |= headerFlagManifest
+	}
+	var blob kzg4844.Blob
+	copy(blob[:], generateMagicHeader(&MagicHeader{
+		Flags:       flags,
+		BlobIndex:   e.blobIndex,
+		TotalBlobs:  e.totalBlobs,
+		Seed:        e.seed,
+		Length:      len(payload),
+		FileSize:    e.manifest.FileSize,
+		Erasure:     e.geometry,
+		Compression: e.opts.Compression,
+	}))
+	encodePayload(&blob, e.headerElements, payload, flags)
+	e.blobIndex++
+	return &blob, nil
+}
+
+// readPayloads reads the next payload, or the next stripe of payloads when the file is erasure coded
+func (e *blobEncoder) readPayloads() error {
+	count := 1
+	if e.opts.ParityShards > 0 {
+		count = e.geometry.stripeDataShards(e.payloadIndex / e.geometry.DataShards)
+	}
+
+	payloads := make([][]byte, count)
+	for i := range payloads {
+		payload, err := e.readPayload()
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+
+	if e.opts.ParityShards > 0 {
+		stripe := erasureGeometry{DataShards: e.geometry.DataShards, ParityShards: e.geometry.ParityShards, DataBlobs: count}
+		var err error
+		if payloads, err = erasureEncode(payloads, stripe, e.capacity); err != nil {
+			return err
+		}
+	}
+	e.pending = payloads
+	return nil
+}
+
+func (e *blobEncoder) readPayload() ([]byte, error) {
+	index := e.payloadIndex
+	e.payloadIndex++
+	if index < len(e.manifestData) {
+		return e.manifestData[index], nil
+	}
+	index -= len(e.manifestData)
+
+	chunk := make([]byte, chunkCapacity(e.opts))
+	n, err := io.ReadFull(e.source, chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error reading blob file: %w", err)
+	}
+	chunk = chunk[:n]
+	if common.Hash(sha256.Sum256(chunk)) != e.manifest.ChunkDigests[index] {
+		return nil, errors.New("blob file changed while it was being encoded")
+	}
+	return chunk, nil
+}
+
+// Close removes the temporary file, if any
+func (e *blobEncoder) Close() error {
+	if e.spool == nil {
+		return nil
+	}
+	e.spool.Close()
+	return os.Remove(e.spool.Name())
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
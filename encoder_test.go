@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+)
+
+// storedFile encodes data with opts and returns the manifest and the file as it is stored in the blobs, i.e.
+// compressed and encrypted
+func storedFile(t *testing.T, data []byte, opts EncodeOptions) (*Manifest, []byte) {
+	blobs, err := encodeBlobsWithMagicHeader(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		manifest *Manifest
+		stored   []byte
+	)
+	for _, blob := range blobs {
+		header, payload, err := decodeMagicBlob(blob[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case manifest != nil && !manifest.complete():
+			if err := manifest.addDigests(payload); err != nil {
+				t.Fatal(err)
+			}
+		case header.Flags&headerFlagManifest != 0:
+			if manifest, err = decodeManifest(payload); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			stored = append(stored, payload...)
+		}
+	}
+	return manifest, stored
+}
+
+func TestBlobEncoderStream(t *testing.T) {
+	data := makeBlob(3*chunkCapacity(EncodeOptions{}) + 100)
+
+	for _, opts := range []EncodeOptions{{}, {Compression: CompressionGzip}, {DataShards: 2, ParityShards: 1}} {
+		expected, err := encodeBlobsWithMagicHeader(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Hide the Seek method so the file is spooled like stdin
+		enc, err := newBlobEncoder(struct{ io.Reader }{bytes.NewReader(data)}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc.TotalBlobs() != len(expected) {
+			t.Fatalf("%+v: expected %d blobs, got %d", opts, len(expected), enc.TotalBlobs())
+		}
+		for i := 0; ; i++ {
+			blob, err := enc.Next()
+			if err == io.EOF {
+				if i != len(expected) {
+					t.Fatalf("%+v: expected %d blobs, got %d", opts, len(expected), i)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeMagicBlob(blob[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := DecodeMagicBlob(expected[i][:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%+v: blob %d differs from the in-memory encoding", opts, i)
+			}
+		}
+
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(enc.spool.Name()); !os.IsNotExist(err) {
+			t.Fatalf("%+v: temporary file was not removed", opts)
+		}
+	}
+}
+
+// An upload whose file cannot be encoded fails instead of ending after the blobs already sent
+func TestEncodeMultipartBlobError(t *testing.T) {
+	readErr := errors.New("read error")
+	r := io.MultiReader(bytes.NewReader(makeBlob(100)), iotest.ErrReader(readErr))
+	if err := EncodeMultipartBlob(context.Background(), make(chan FullBlobStruct), r, 2, EncodeOptions{}); !errors.Is(err, readErr) {
+		t.Errorf("expected the read error, got %v", err)
+	}
+
+	blobs := make(chan FullBlobStruct)
+	if err := EncodeMultipartBlob(context.Background(), blobs, bytes.NewReader(makeBlob(100)), 0, EncodeOptions{}); err == nil {
+		t.Error("expected error for 0 blobs per tx")
+	}
+	if _, ok := <-blobs; ok {
+		t.Error("expected the channel to be closed")
+	}
+}
+
+// The encoder stops when nothing reads its blobs anymore
+func TestEncodeMultipartBlobCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	blobs := make(chan FullBlobStruct)
+	done := make(chan error)
+	go func() {
+		done <- EncodeMultipartBlob(ctx, blobs, bytes.NewReader(makeBlob(3*chunkCapacity(EncodeOptions{}))), 1, EncodeOptions{})
+	}()
+	<-blobs
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the encoder to be cancelled, got %v", err)
+	}
+}
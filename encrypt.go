@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted files are split in segments of encryptionSegmentSize bytes that are sealed with ChaCha20-Poly1305
+// using a random content key. The nonce of every segment is its index (11 bytes) followed by a flag set on the
+// last segment, so segments can not be reordered or truncated.
+//
+// The content key is wrapped once per recipient with a key derived from X25519(ephemeral, recipient) and stored
+// in the manifest.
+const (
+	encryptionSegmentSize = 64 * 1024
+	keyWrapInfo           = "blobtoss key wrap v1"
+)
+
+// WrappedKey is the content key of a file encrypted for one recipient
+type WrappedKey struct {
+	EphemeralKey []byte `json:"ephemeralKey"`
+	Key          []byte `json:"key"`
+}
+
+// Encryption is stored in the manifest of encrypted files
+type Encryption struct {
+	Recipients []WrappedKey `json:"recipients"`
+	// Metadata is set when the name, content type and archive index of the file are stored at the start of the
+	// encrypted stream (see writeFileMetadata) instead of in the manifest
+	Metadata bool `json:"metadata,omitempty"`
+}
+
+// fileMetadata is the part of the manifest that is encrypted with the file
+type fileMetadata struct {
+	Filename    string         `json:"filename,omitempty"`
+	ContentType string         `json:"contentType,omitempty"`
+	Archive     []ArchiveEntry `json:"archive,omitempty"`
+}
+
+// writeFileMetadata writes the metadata to w as its length (4 bytes) followed by its JSON
+func writeFileMetadata(w io.Writer, metadata fileMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readFileMetadata returns a writer that reads the metadata written by writeFileMetadata at the start of the
+// decrypted stream, passes it to onMetadata and the rest of the stream to out
+func readFileMetadata(onMetadata func(fileMetadata) error, out func([]byte) error) func([]byte) error {
+	var (
+		buf  []byte
+		done bool
+	)
+	return func(data []byte) error {
+		if done {
+			return out(data)
+		}
+		buf = append(buf, data...)
+		if len(buf) < 4 {
+			return nil
+		}
+		end := 4 + uint64(binary.BigEndian.Uint32(buf))
+		if uint64(len(buf)) < end {
+			return nil
+		}
+		var metadata fileMetadata
+		if err := json.Unmarshal(buf[4:end], &metadata); err != nil {
+			return fmt.Errorf("invalid encrypted metadata: %w", err)
+		}
+		done = true
+		rest := buf[end:]
+		buf = nil
+		if err := onMetadata(metadata); err != nil {
+			return err
+		}
+		if len(rest) == 0 {
+			return nil
+		}
+		return out(rest)
+	}
+}
+
+// parseX25519Key decodes a hex encoded X25519 key
+func parseX25519Key(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(key) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("invalid key length %d", len(key))
+	}
+	return key, nil
+}
+
+// readIdentity reads a hex encoded X25519 private key from a file
+func readIdentity(filename string) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity file: %w", err)
+	}
+	return parseX25519Key(string(data))
+}
+
+func newIdentity() (identity, recipient []byte, err error) {
+	identity = make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(identity); err != nil {
+		return nil, nil, err
+	}
+	recipient, err = curve25519.X25519(identity, curve25519.Basepoint)
+	return identity, recipient, err
+}
+
+func keyWrapAEAD(sharedSecret, ephemeralKey, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralKey...), recipient...)
+	wrapKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, salt, []byte(keyWrapInfo)), wrapKey); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(wrapKey)
+}
+
+func wrapKey(contentKey, recipient []byte) (WrappedKey, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return WrappedKey{}, err
+	}
+	ephemeralKey, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	sharedSecret, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	aead, err := keyWrapAEAD(sharedSecret, ephemeralKey, recipient)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{
+		EphemeralKey: ephemeralKey,
+		Key:          aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), contentKey, nil),
+	}, nil
+}
+
+// unwrapKey returns the content key if one of the recipients matches the identity
+func unwrapKey(encryption *Encryption, identity []byte) ([]byte, error) {
+	recipient, err := curve25519.X25519(identity, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	for _, wrapped := range encryption.Recipients {
+		sharedSecret, err := curve25519.X25519(identity, wrapped.EphemeralKey)
+		if err != nil {
+			continue
+		}
+		aead, err := keyWrapAEAD(sharedSecret, wrapped.EphemeralKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if contentKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), wrapped.Key, nil); err == nil {
+			return contentKey, nil
+		}
+	}
+	return nil, errors.New("file is not encrypted for this identity")
+}
+
+func segmentNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newEncryption generates a content key and wraps it for all the recipients (X25519 public keys)
+func newEncryption(recipients [][]byte) ([]byte, *Encryption, error) {
+	contentKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, nil, err
+	}
+
+	encryption := &Encryption{}
+	for _, recipient := range recipients {
+		wrapped, err := wrapKey(contentKey, recipient)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.Recipients = append(encryption.Recipients, wrapped)
+	}
+	return contentKey, encryption, nil
+}
+
+// encryptor encrypts the data written to it one segment at a time and writes the result to out
+type encryptor struct {
+	aead    cipher.AEAD
+	out     io.Writer
+	buf     []byte
+	segment uint64
+}
+
+func newEncryptor(contentKey []byte, out io.Writer) (*encryptor, error) {
+	aead, err := chacha20poly1305.New(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{aead: aead, out: out}, nil
+}
+
+func (e *encryptor) seal(plaintext []byte, last bool) error {
+	_, err := e.out.Write(e.aead.Seal(nil, segmentNonce(e.segment, last), plaintext, nil))
+	e.segment++
+	return err
+}
+
+func (e *encryptor) Write(data []byte) (int, error) {
+	e.buf = append(e.buf, data...)
+
+	// The last segment is only known on Close, so always keep at least one segment
+	for len(e.buf) > encryptionSegmentSize {
+		if err := e.seal(e.buf[:encryptionSegmentSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[encryptionSegmentSize:]
+	}
+	return len(data), nil
+}
+
+// Close encrypts the last segment, which may be empty
+func (e *encryptor) Close() error {
+	err := e.seal(e.buf, true)
+	e.buf = nil
+	return err
+}
+
+// decryptor decrypts the chunks written to it as they arrive and passes the result to out
+type decryptor struct {
+	aead    cipher.AEAD
+	out     func([]byte) error
+	buf     []byte
+	segment uint64
+}
+
+func newDecryptor(contentKey []byte, out func([]byte) error) (*decryptor, error) {
+	aead, err := chacha20poly1305.New(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptor{aead: aead, out: out}, nil
+}
+
+func (d *decryptor) open(ciphertext []byte, last bool) error {
+	plaintext, err := d.aead.Open(nil, segmentNonce(d.segment, last), ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("error decrypting segment %d: %w", d.segment, err)
+	}
+	d.segment++
+	return d.out(plaintext)
+}
+
+func (d *decryptor) Write(chunk []byte) error {
+	d.buf = append(d.buf, chunk...)
+
+	// The last segment is only known on Close, so always keep at least one segment
+	segmentSize := encryptionSegmentSize + chacha20poly1305.Overhead
+	for len(d.buf) > segmentSize {
+		if err := d.open(d.buf[:segmentSize], false); err != nil {
+			return err
+		}
+		d.buf = d.buf[segmentSize:]
+	}
+	return nil
+}
+
+// Close decrypts the last segment
+func (d *decryptor) Close() error {
+	err := d.open(d.buf, true)
+	d.buf = nil
+	return err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func decryptAll(t *testing.T, ciphertext []byte, encryption *Encryption, identity []byte) ([]byte, error) {
+	contentKey, err := unwrapKey(encryption, identity)
+	if err != nil {
+		return nil, err
+	}
+	var plaintext []byte
+	out := func(b []byte) error {
+		plaintext = append(plaintext, b...)
+		return nil
+	}
+	if encryption.Metadata {
+		out = readFileMetadata(func(fileMetadata) error { return nil }, out)
+	}
+	d, err := newDecryptor(contentKey, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := 0; start < len(ciphertext); start += 10000 {
+		end := start + 10000
+		if end > len(ciphertext) {
+			end = len(ciphertext)
+		}
+		if err := d.Write(ciphertext[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	return plaintext, d.Close()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	alice, alicePub, _ := newIdentity()
+	bob, bobPub, _ := newIdentity()
+	eve, _, _ := newIdentity()
+
+	for _, data := range [][]byte{{}, makeBlob(100), makeBlob(2 * encryptionSegmentSize), makeBlob(3*encryptionSegmentSize + 1)} {
+		manifest, ciphertext := storedFile(t, data, EncodeOptions{Recipients: [][]byte{alicePub, bobPub}})
+		encryption := manifest.Encryption
+		if encryption == nil {
+			t.Fatal("manifest has no keys")
+		}
+		for _, identity := range [][]byte{alice, bob} {
+			plaintext, err := decryptAll(t, ciphertext, encryption, identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, plaintext) {
+				t.Fatalf("round trip failed: expected %d bytes, got %d", len(data), len(plaintext))
+			}
+		}
+		if _, err := decryptAll(t, ciphertext, encryption, eve); err == nil {
+			t.Fatal("expected error decrypting with another identity")
+		}
+
+		// Truncating the last segment must be detected
+		if len(data) > encryptionSegmentSize {
+			if _, err := decryptAll(t, ciphertext[:len(ciphertext)-100], encryption, alice); err == nil {
+				t.Fatal("expected error decrypting truncated file")
+			}
+		}
+	}
+}
+
+// The name and type of an encrypted file are only readable after decrypting it
+func TestEncryptedMetadata(t *testing.T) {
+	identity, recipient, _ := newIdentity()
+	data := makeBlob(1000)
+	opts := EncodeOptions{
+		Recipients:  [][]byte{recipient},
+		Compression: CompressionGzip,
+		Filename:    "secret-report.pdf",
+		ContentType: "application/pdf",
+	}
+	blobs, err := encodeBlobsWithMagicHeader(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := decodeMagicBlob(blobs[0][:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(payload, []byte("secret-report")) || bytes.Contains(payload, []byte("application/pdf")) {
+		t.Fatalf("manifest contains the name of the file: %s", payload)
+	}
+
+	node := &fakeBeaconNode{firstSlot: 100, blobs: blobs}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	var manifest *Manifest
+	blobChannel := make(chan []byte)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- GetMultiPartBlob(blobChannel, server.URL, node.firstSlot, DownloadOptions{
+			Identity:   identity,
+			OnManifest: func(m *Manifest) { manifest = m },
+		})
+	}()
+	var dec []byte
+	for chunk := range blobChannel {
+		if manifest == nil {
+			t.Fatal("chunk received before the manifest")
+		}
+		dec = append(dec, chunk...)
+	}
+	if err := <-errChannel; err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Filename != opts.Filename || manifest.ContentType != opts.ContentType {
+		t.Fatalf("expected %s (%s), got %s (%s)", opts.Filename, opts.ContentType, manifest.Filename, manifest.ContentType)
+	}
+	if !bytes.Equal(data, dec) {
+		t.Fatalf("round trip failed: expected %d bytes, got %d", len(data), len(dec))
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Erasure coding splits the payloads of a file (manifest included) in stripes of k data shards. Every stripe is
+// followed by m parity shards, so any k of the k+m blobs of a stripe are enough to rebuild it. The last stripe
+// may have less than k data shards; the missing ones are zero shards that are not sent.
+//
+// Every data shard starts with the length of its payload (4 bytes) and is padded with zeros to the blob capacity.
+const erasureLengthPrefix = 4
+
+// erasureGeometry describes how the blobs of an erasure coded file are laid out
+type erasureGeometry struct {
+	DataShards   int
+	ParityShards int
+	// DataBlobs is the number of payloads of the file (manifest and chunks)
+	DataBlobs int
+}
+
+func (g erasureGeometry) stripes() int {
+	return (g.DataBlobs + g.DataShards - 1) / g.DataShards
+}
+
+// totalBlobs returns the number of blobs sent, data and parity
+func (g erasureGeometry) totalBlobs() int {
+	return g.DataBlobs + g.stripes()*g.ParityShards
+}
+
+// position returns the stripe of a blob and its shard index within the stripe
+func (g erasureGeometry) position(blobIndex int) (stripe, shard int) {
+	stripe = blobIndex / (g.DataShards + g.ParityShards)
+	shard = blobIndex % (g.DataShards + g.ParityShards)
+
+	// The last stripe can be shorter, so parity shards come earlier
+	if dataShards := g.stripeDataShards(stripe); shard >= dataShards {
+		shard += g.DataShards - dataShards
+	}
+	return stripe, shard
+}
+
+// stripeDataShards returns the number of data shards of a stripe that are actually sent
+func (g erasureGeometry) stripeDataShards(stripe int) int {
+	if remaining := g.DataBlobs - stripe*g.DataShards; remaining < g.DataShards {
+		return remaining
+	}
+	return g.DataShards
+}
+
+func validateErasureOptions(dataShards, parityShards int) error {
+	if dataShards < 1 || parityShards < 1 || dataShards+parityShards > 256 {
+		return fmt.Errorf("invalid erasure coding parameters: %d data and %d parity blobs (max 256 in total)", dataShards, parityShards)
+	}
+	return nil
+}
+
+// erasureEncode returns the shards of all the payloads in the order they must be sent
+func erasureEncode(payloads [][]byte, geometry erasureGeometry, shardSize int) ([][]byte, error) {
+	enc, err := reedsolomon.New(geometry.DataShards, geometry.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	var out [][]byte
+	for stripe := 0; stripe < geometry.stripes(); stripe++ {
+		shards := make([][]byte, geometry.DataShards+geometry.ParityShards)
+		for i := range shards {
+			shards[i] = make([]byte, shardSize)
+		}
+		for i := 0; i < geometry.stripeDataShards(stripe); i++ {
+			payload := payloads[stripe*geometry.DataShards+i]
+			if len(payload)+erasureLengthPrefix > shardSize {
+				return nil, fmt.Errorf("payload of %d bytes does not fit in a shard of %d bytes", len(payload), shardSize)
+			}
+			binary.BigEndian.PutUint32(shards[i], uint32(len(payload)))
+			copy(shards[i][erasureLengthPrefix:], payload)
+		}
+
+		if err := enc.Encode(shards); err != nil {
+			return nil, err
+		}
+		out = append(out, shards[:geometry.stripeDataShards(stripe)]...)
+		out = append(out, shards[geometry.DataShards:]...)
+	}
+	return out, nil
+}
+
+// erasureDecoder collects the shards of a file and rebuilds its payloads one stripe at a time
+type erasureDecoder struct {
+	geometry  erasureGeometry
+	shardSize int
+	enc       reedsolomon.Encoder
+	stripes   map[int][][]byte
+	received  map[int]int
+	next      int
+}
+
+func newErasureDecoder(geometry erasureGeometry, shardSize int) (*erasureDecoder, error) {
+	if err := validateErasureOptions(geometry.DataShards, geometry.ParityShards); err != nil {
+		return nil, err
+	}
+	enc, err := reedsolomon.New(geometry.DataShards, geometry.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	return &erasureDecoder{
+		geometry:  geometry,
+		shardSize: shardSize,
+		enc:       enc,
+		stripes:   make(map[int][][]byte),
+		received:  make(map[int]int),
+	}, nil
+}
+
+// Add stores the shard of the blob with the given index
+func (d *erasureDecoder) Add(blobIndex int, shard []byte) error {
+	if blobIndex >= d.geometry.totalBlobs() {
+		return fmt.Errorf("blob index %d out of range", blobIndex)
+	}
+	if len(shard) != d.shardSize {
+		return fmt.Errorf("invalid shard size %d, expected %d", len(shard), d.shardSize)
+	}
+
+	stripe, index := d.geometry.position(blobIndex)
+	if stripe < d.next {
+		return nil
+	}
+	shards, ok := d.stripes[stripe]
+	if !ok {
+		shards = make([][]byte, d.geometry.DataShards+d.geometry.ParityShards)
+		// Data shards that are not sent are known to be zero
+		for i := d.geometry.stripeDataShards(stripe); i < d.geometry.DataShards; i++ {
+			shards[i] = make([]byte, d.shardSize)
+		}
+		d.stripes[stripe] = shards
+	}
+	if shards[index] == nil {
+		shards[index] = shard
+		d.received[stripe]++
+	}
+	return nil
+}
+
+// Next returns the payloads of the next stripe once enough shards have been received to rebuild it
+func (d *erasureDecoder) Next() ([][]byte, error) {
+	if d.Done() {
+		return nil, nil
+	}
+	stripe := d.next
+	zeroShards := d.geometry.DataShards - d.geometry.stripeDataShards(stripe)
+	if d.received[stripe]+zeroShards < d.geometry.DataShards {
+		return nil, nil
+	}
+
+	shards := d.stripes[stripe]
+	if err := d.enc.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+
+	payloads := make([][]byte, d.geometry.stripeDataShards(stripe))
+	for i := range payloads {
+		length := int(binary.BigEndian.Uint32(shards[i]))
+		if length+erasureLengthPrefix > d.shardSize {
+			return nil, fmt.Errorf("invalid payload length %d in stripe %d", length, stripe)
+		}
+		payloads[i] = shards[i][erasureLengthPrefix : erasureLengthPrefix+length]
+	}
+
+	delete(d.stripes, stripe)
+	delete(d.received, stripe)
+	d.next++
+	return payloads, nil
+}
+
+// Done reports whether all the stripes have been rebuilt
+func (d *erasureDecoder) Done() bool {
+	return d.next == d.geometry.stripes()
+}
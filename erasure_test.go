@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestErasureCodingRoundTrip(t *testing.T) {
+	opts := EncodeOptions{DataShards: 3, ParityShards: 2}
+	data := makeBlob(7*blobCapacity(magicHeaderV2, opts.flags()) + 100)
+
+	blobs, err := encodeBlobsWithMagicHeader(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 9 payloads (manifest and 8 chunks) in 3 stripes
+	if len(blobs) != 9+3*2 {
+		t.Fatalf("expected 15 blobs, got %d", len(blobs))
+	}
+
+	// Drop 2 blobs of every stripe, including the manifest and the parity of the last one
+	lost := map[int]bool{0: true, 4: true, 5: true, 7: true, 13: true, 14: true}
+
+	var decoder *erasureDecoder
+	var payloads [][]byte
+	for i, blob := range blobs {
+		if lost[i] {
+			continue
+		}
+		header, err := parseMagicHeader(blob[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoder == nil {
+			decoder, err = newErasureDecoder(header.Erasure, header.Length)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		payload, err := DecodeMagicBlob(blob[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoder.Add(header.BlobIndex, payload); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			stripe, err := decoder.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stripe == nil {
+				break
+			}
+			payloads = append(payloads, stripe...)
+		}
+	}
+	if !decoder.Done() {
+		t.Fatal("file was not rebuilt")
+	}
+
+	manifest, err := decodeManifest(payloads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := newManifestVerifier(manifest)
+	for _, payload := range payloads[1:] {
+		if err := verifier.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := verifier.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if dec := bytes.Join(payloads[1:], nil); !bytes.Equal(dec, data) {
+		t.Fatalf("round trip failed: expected %d bytes, got %d", len(data), len(dec))
+	}
+}
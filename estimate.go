@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/urfave/cli"
+)
+
+// slotTime is the time between two blocks
+const slotTime = 12 * time.Second
+
+// maxBlobsPerBlock is the blob limit of a block (Cancun)
+const maxBlobsPerBlock = params.MaxBlobGasPerBlock / params.BlobTxBlobGasPerBlob
+
+// uploadEstimate is what a multipart upload needs
+type uploadEstimate struct {
+	Blobs        int
+	Txs          int
+	BlobGas      uint64
+	ExecutionGas uint64 // gas limit of all the transactions
+}
+
+func newUploadEstimate(blobs int, blobsPerTx int, gasLimit uint64) uploadEstimate {
+	txs := (blobs + blobsPerTx - 1) / blobsPerTx
+	return uploadEstimate{
+		Blobs:        blobs,
+		Txs:          txs,
+		BlobGas:      uint64(blobs) * params.BlobTxBlobGasPerBlob,
+		ExecutionGas: uint64(txs) * gasLimit,
+	}
+}
+
+// cost returns the wei paid for the upload with the given fee per gas and fee per blob gas
+func (e uploadEstimate) cost(feePerGas, feePerBlobGas *big.Int) *big.Int {
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(e.ExecutionGas), feePerGas)
+	return cost.Add(cost, new(big.Int).Mul(new(big.Int).SetUint64(e.BlobGas), feePerBlobGas))
+}
+
+// blocks returns the blocks needed to include all the transactions when every block is filled with them,
+// and when each transaction waits for the previous one to be included
+func (e uploadEstimate) blocks(blobsPerTx int) (int, int) {
+	txsPerBlock := int(maxBlobsPerBlock) / blobsPerTx
+	return (e.Txs + txsPerBlock - 1) / txsPerBlock, e.Txs
+}
+
+func formatEther(wei *big.Int) string {
+	ether := new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(params.Ether))
+	return ether.Text('f', 9) + " ETH"
+}
+
+func EstimateApp(cliCtx *cli.Context) error {
+	addr := cliCtx.String(TxRPCURLFlag.Name)
+	file := cliCtx.String(TxBlobFileFlag.Name)
+	gasLimit := cliCtx.Uint64(TxGasLimitFlag.Name)
+	gasPrice := cliCtx.String(TxGasPriceFlag.Name)
+	priorityGasPrice := cliCtx.String(TxPriorityGasPrice.Name)
+	maxFeePerBlobGas := cliCtx.String(TxMaxFeePerBlobGas.Name)
+	blobsPerTx := cliCtx.Int(MultiTxBlobsPerTx.Name)
+
+	if blobsPerTx < 1 || blobsPerTx > int(maxBlobsPerBlock) {
+		return fmt.Errorf("invalid blobs per tx %d, a block holds at most %d blobs", blobsPerTx, maxBlobsPerBlock)
+	}
+	opts, err := readEncodeOptions(cliCtx)
+	if err != nil {
+		return err
+	}
+	closer, data, err := openUpload(file, &opts)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	// Only the layout of the blobs is computed, not their commitments
+	enc, err := newBlobEncoder(data, opts)
+	if err != nil {
+		return err
+	}
+	enc.Close()
+
+	estimate := newUploadEstimate(enc.TotalBlobs(), blobsPerTx, gasLimit)
+	fullBlocks, sequentialBlocks := estimate.blocks(blobsPerTx)
+	fmt.Printf("Blobs:          %d\n", estimate.Blobs)
+	fmt.Printf("Transactions:   %d (%d blobs per tx)\n", estimate.Txs, blobsPerTx)
+	fmt.Printf("Blob gas:       %d\n", estimate.BlobGas)
+	fmt.Printf("Execution gas:  %d (gas limit %d per tx)\n", estimate.ExecutionGas, gasLimit)
+	fmt.Printf("Inclusion time: %v (%d blocks) if blocks are filled with the upload, %v (%d blocks) with one transaction per block\n",
+		time.Duration(fullBlocks)*slotTime, fullBlocks, time.Duration(sequentialBlocks)*slotTime, sequentialBlocks)
+
+	ctx := context.Background()
+	client, err := ethclient.DialContext(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	header, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error getting latest header: %w", err)
+	}
+	baseFee := header.BaseFee
+	if baseFee == nil {
+		baseFee = new(big.Int)
+	}
+	blobFee, err := blobBaseFee(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error getting blob base fee: %w", err)
+	}
+
+	var maxFeePerGas *big.Int
+	if gasPrice == "" {
+		if maxFeePerGas, err = client.SuggestGasPrice(ctx); err != nil {
+			return fmt.Errorf("error getting suggested gas price: %w", err)
+		}
+	} else {
+		fee, err := DecodeUint256String(gasPrice)
+		if err != nil {
+			return fmt.Errorf("%w: invalid gas price", err)
+		}
+		maxFeePerGas = fee.ToBig()
+	}
+	tip := new(big.Int).Set(maxFeePerGas)
+	if priorityGasPrice != "" {
+		fee, err := DecodeUint256String(priorityGasPrice)
+		if err != nil {
+			return fmt.Errorf("%w: invalid priority gas price", err)
+		}
+		if fee.ToBig().Cmp(tip) < 0 {
+			tip = fee.ToBig()
+		}
+	}
+	maxFeePerBlobGas256, err := resolveMaxFeePerBlobGas(ctx, client, maxFeePerBlobGas, cliCtx.Float64(TxBlobFeeHeadroomFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	// The fee per gas paid is the base fee plus the tip, capped by max_fee_per_gas
+	feePerGas := new(big.Int).Add(baseFee, tip)
+	if feePerGas.Cmp(maxFeePerGas) > 0 {
+		feePerGas = maxFeePerGas
+	}
+	fmt.Printf("Current fees:   base fee %v wei, tip %v wei, blob base fee %v wei\n", baseFee, tip, blobFee)
+	fmt.Printf("Expected cost:  %s at the current fees\n", formatEther(estimate.cost(feePerGas, blobFee)))
+	fmt.Printf("Maximum cost:   %s at max_fee_per_gas=%v and max_fee_per_blob_gas=%v\n",
+		formatEther(estimate.cost(maxFeePerGas, maxFeePerBlobGas256.ToBig())), maxFeePerGas, maxFeePerBlobGas256)
+	if maxFeePerBlobGas256.ToBig().Cmp(blobFee) < 0 {
+		fmt.Println("Warning: max_fee_per_blob_gas is below the blob base fee, the transactions would not be included now")
+	}
+	if maxFeePerGas.Cmp(baseFee) < 0 {
+		fmt.Println("Warning: max_fee_per_gas is below the base fee, the transactions would not be included now")
+	}
+	return nil
+}
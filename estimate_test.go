@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestUploadEstimate(t *testing.T) {
+	estimate := newUploadEstimate(13, 6, 21000)
+	if estimate.Txs != 3 || estimate.BlobGas != 13*131072 || estimate.ExecutionGas != 3*21000 {
+		t.Fatalf("unexpected estimate %+v", estimate)
+	}
+	if cost := estimate.cost(big.NewInt(10), big.NewInt(2)); cost.Int64() != 3*21000*10+13*131072*2 {
+		t.Fatalf("unexpected cost %v", cost)
+	}
+	if full, sequential := estimate.blocks(6); full != 3 || sequential != 3 {
+		t.Fatalf("unexpected blocks %d, %d", full, sequential)
+	}
+
+	// Two transactions of 3 blobs fit in a block
+	estimate = newUploadEstimate(13, 3, 21000)
+	if full, sequential := estimate.blocks(3); full != 3 || sequential != 5 {
+		t.Fatalf("unexpected blocks %d, %d", full, sequential)
+	}
+	if got := formatEther(big.NewInt(1500000000000000000)); got != "1.500000000 ETH" {
+		t.Fatalf("unexpected format %s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/holiman/uint256"
+)
+
+// maxFeeAuto is the --max-fee-per-blob-gas value that sets the fee from the current blob base fee
+const maxFeeAuto = "auto"
+
+// blobHeader holds the fields of the latest header used to compute the blob base fee
+type blobHeader struct {
+	ExcessBlobGas *hexutil.Uint64 `json:"excessBlobGas"`
+	BlobGasUsed   *hexutil.Uint64 `json:"blobGasUsed"`
+	// RequestsHash is added by Prague, which also changed the blob target and the base fee update fraction
+	RequestsHash *common.Hash `json:"requestsHash"`
+}
+
+// blobBaseFee returns the blob base fee of the next block. It uses eth_blobBaseFee, or computes it from the excess
+// blob gas of the latest header on nodes without it. The computation only knows the blob parameters of Cancun, so
+// it fails on later forks.
+func blobBaseFee(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+	var fee hexutil.Big
+	if err := client.Client().CallContext(ctx, &fee, "eth_blobBaseFee"); err == nil {
+		return fee.ToInt(), nil
+	}
+
+	var header *blobHeader
+	if err := client.Client().CallContext(ctx, &header, "eth_getBlockByNumber", "latest", false); err != nil {
+		return nil, fmt.Errorf("error getting latest header: %w", err)
+	}
+	if header == nil {
+		return nil, errors.New("latest header not found")
+	}
+	if header.ExcessBlobGas == nil || header.BlobGasUsed == nil {
+		return nil, errors.New("latest block has no blob gas fields, the chain has not activated Cancun")
+	}
+	if header.RequestsHash != nil {
+		return nil, fmt.Errorf("the node has no eth_blobBaseFee and the chain is past Cancun, whose blob parameters are the only ones known: set --%s to a value in wei", TxMaxFeePerBlobGas.Name)
+	}
+	return eip4844.CalcBlobFee(eip4844.CalcExcessBlobGas(uint64(*header.ExcessBlobGas), uint64(*header.BlobGasUsed))), nil
+}
+
+// applyHeadroom multiplies fee by headroom, rounding up so a fee is never lowered to 0
+func applyHeadroom(fee *big.Int, headroom float64) *big.Int {
+	f := new(big.Float).Mul(new(big.Float).SetInt(fee), big.NewFloat(headroom))
+	result, accuracy := f.Int(nil)
+	if accuracy == big.Below {
+		result.Add(result, big.NewInt(1))
+	}
+	return result
+}
+
+// resolveMaxFeePerBlobGas parses --max-fee-per-blob-gas. With auto, the fee is the current blob base fee times headroom.
+func resolveMaxFeePerBlobGas(ctx context.Context, client *ethclient.Client, maxFeePerBlobGas string, headroom float64) (*uint256.Int, error) {
+	if maxFeePerBlobGas != maxFeeAuto {
+		fee, err := DecodeUint256String(maxFeePerBlobGas)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid max_fee_per_blob_gas", err)
+		}
+		return fee, nil
+	}
+
+	if headroom < 1 {
+		return nil, fmt.Errorf("invalid blob fee headroom %v, it must be at least 1", headroom)
+	}
+	baseFee, err := blobBaseFee(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("error getting blob base fee: %w", err)
+	}
+	fee, overflow := uint256.FromBig(applyHeadroom(baseFee, headroom))
+	if overflow {
+		return nil, fmt.Errorf("max_fee_per_blob_gas is too high, blob base fee is %v", baseFee)
+	}
+	log.Printf("Blob base fee is %v wei, using max_fee_per_blob_gas=%v (x%v)", baseFee, fee, headroom)
+	return fee, nil
+}
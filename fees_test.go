@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeEthAPI is the eth namespace of a node. eth_blobBaseFee is missing if blobBaseFee is nil.
+type fakeEthAPI struct {
+	blobBaseFee *big.Int
+	header      *types.Header
+	// requestsHash is added to the header, as on chains past Prague
+	requestsHash *common.Hash
+}
+
+func (f *fakeEthAPI) BlobBaseFee() (*hexutil.Big, error) {
+	if f.blobBaseFee == nil {
+		return nil, errors.New("the method eth_blobBaseFee does not exist/is not available")
+	}
+	return (*hexutil.Big)(f.blobBaseFee), nil
+}
+
+func (f *fakeEthAPI) GetBlockByNumber(number string, fullTx bool) (map[string]interface{}, error) {
+	enc, err := json.Marshal(f.header)
+	if err != nil {
+		return nil, err
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(enc, &header); err != nil {
+		return nil, err
+	}
+	if f.requestsHash != nil {
+		header["requestsHash"] = f.requestsHash
+	}
+	return header, nil
+}
+
+func newFakeEthClient(t *testing.T, api *fakeEthAPI) *ethclient.Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	client, err := ethclient.Dial(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestResolveMaxFeePerBlobGas(t *testing.T) {
+	ctx := context.Background()
+	excessBlobGas, blobGasUsed := uint64(10*1<<20), uint64(6*1<<17)
+	api := &fakeEthAPI{header: &types.Header{
+		Number:        big.NewInt(1),
+		Difficulty:    new(big.Int),
+		BaseFee:       big.NewInt(7),
+		ExcessBlobGas: &excessBlobGas,
+		BlobGasUsed:   &blobGasUsed,
+	}}
+	client := newFakeEthClient(t, api)
+
+	// Without eth_blobBaseFee, the fee of the next block is computed from the latest header
+	expected := eip4844.CalcBlobFee(eip4844.CalcExcessBlobGas(excessBlobGas, blobGasUsed))
+	fee, err := resolveMaxFeePerBlobGas(ctx, client, maxFeeAuto, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee.ToBig().Cmp(new(big.Int).Mul(expected, big.NewInt(2))) != 0 {
+		t.Fatalf("expected %v, got %v", 2*expected.Int64(), fee)
+	}
+
+	// Past Cancun, the blob parameters of the chain are not known
+	api.requestsHash = &common.Hash{}
+	if _, err := resolveMaxFeePerBlobGas(ctx, client, maxFeeAuto, 2); err == nil {
+		t.Fatal("expected error computing the blob base fee past Cancun")
+	}
+
+	api.blobBaseFee = big.NewInt(1)
+	if fee, err = resolveMaxFeePerBlobGas(ctx, client, maxFeeAuto, 1.5); err != nil || fee.Uint64() != 2 {
+		t.Fatalf("expected headroom to round up to 2, got %v (%v)", fee, err)
+	}
+	if _, err = resolveMaxFeePerBlobGas(ctx, client, maxFeeAuto, 0.5); err == nil {
+		t.Fatal("expected error for headroom below 1")
+	}
+	if fee, err = resolveMaxFeePerBlobGas(ctx, client, "3000000000", 2); err != nil || fee.Uint64() != 3000000000 {
+		t.Fatalf("expected the fixed fee, got %v (%v)", fee, err)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/urfave/cli"
 )
 
@@ -12,7 +15,7 @@ var (
 	}
 	TxBlobFileFlag = cli.StringFlag{
 		Name:     "blob-file",
-		Usage:    "Blob file data",
+		Usage:    "Blob file data, or - to read it from stdin",
 		Required: true,
 	}
 	TxToFlag = cli.StringFlag{
@@ -26,9 +29,42 @@ var (
 		Value: "0x0",
 	}
 	TxPrivateKeyFlag = cli.StringFlag{
-		Name:     "private-key",
-		Usage:    "tx private key",
-		Required: true,
+		Name:  "private-key",
+		Usage: "tx private key in hex. It is visible in the shell history and to other users, prefer the other key flags",
+	}
+	TxPrivateKeyEnvFlag = cli.StringFlag{
+		Name:  "private-key-env",
+		Usage: "Name of the environment variable holding the tx private key in hex",
+	}
+	TxKeystoreFlag = cli.StringFlag{
+		Name:  "keystore",
+		Usage: "JSON keystore file (go-ethereum format) of the tx private key. The passphrase is asked unless --password-file is set",
+	}
+	TxMnemonicFileFlag = cli.StringFlag{
+		Name:  "mnemonic-file",
+		Usage: "File holding the BIP-39 mnemonic the tx private key is derived from. --password-file sets its passphrase",
+	}
+	TxDerivationPathFlag = cli.StringFlag{
+		Name:  "derivation-path",
+		Usage: "BIP-32 derivation path of the tx private key from --mnemonic-file",
+		Value: accounts.DefaultBaseDerivationPath.String(),
+	}
+	TxSignerURLFlag = cli.StringFlag{
+		Name:  "signer-url",
+		Usage: "JSON-RPC endpoint of a remote signer (Clef, Web3Signer...) signing the transactions instead of a local key",
+	}
+	TxSignerAddressFlag = cli.StringFlag{
+		Name:  "signer-address",
+		Usage: "Address of the account of the remote signer",
+	}
+	TxSignerMethodFlag = cli.StringFlag{
+		Name:  "signer-method",
+		Usage: "Signing method of the remote signer: eth_signTransaction, or account_signTransaction for Clef",
+		Value: "eth_signTransaction",
+	}
+	TxPasswordFileFlag = cli.StringFlag{
+		Name:  "password-file",
+		Usage: "File whose first line is the passphrase of --keystore or --mnemonic-file",
 	}
 	TxNonceFlag = cli.Int64Flag{
 		Name:  "nonce",
@@ -51,8 +87,45 @@ var (
 	}
 	TxMaxFeePerBlobGas = cli.StringFlag{
 		Name:  "max-fee-per-blob-gas",
-		Usage: "Sets the max_fee_per_blob_gas",
-		Value: "3000000000",
+		Usage: "Sets the max_fee_per_blob_gas, or auto to use the current blob base fee times --blob-fee-headroom",
+		Value: maxFeeAuto,
+	}
+	TxBlobFeeHeadroomFlag = cli.Float64Flag{
+		Name:  "blob-fee-headroom",
+		Usage: "Multiplier applied to the blob base fee with --max-fee-per-blob-gas auto. The blob base fee rises at most 12.5% per block",
+		Value: 2,
+	}
+	TxBumpAfterFlag = cli.Uint64Flag{
+		Name:  "bump-after",
+		Usage: "Replace a blob transaction with higher fees after this many blocks without inclusion (0, the default, disables it)",
+	}
+	TxMaxBumpsFlag = cli.IntFlag{
+		Name:  "max-bumps",
+		Usage: "Maximum number of replacements of a blob transaction. Each one raises its fees by --bump-percent",
+		Value: 3,
+	}
+	TxBumpPercentFlag = cli.Uint64Flag{
+		Name:  "bump-percent",
+		Usage: "Fee increase of replacement transactions. The blob pool of geth requires 100%",
+		Value: 100,
+	}
+	TxConfirmationsFlag = cli.Uint64Flag{
+		Name:  "confirmations",
+		Usage: "Number of blocks, including its own, that must hold a transaction before it counts as included",
+		Value: 1,
+	}
+	TxReceiptTimeoutFlag = cli.DurationFlag{
+		Name:  "receipt-timeout",
+		Usage: "Maximum wait for the receipt of a transaction (0 waits forever)",
+		Value: time.Hour,
+	}
+	TxJournalFlag = cli.StringFlag{
+		Name:  "journal",
+		Usage: "File where the progress of a multipart upload is written (blobtoss-<seed>.journal by default)",
+	}
+	TxResumeFlag = cli.StringFlag{
+		Name:  "resume",
+		Usage: "Journal of an interrupted upload to resume. The file must be the same, and its encoding options are taken from the journal",
 	}
 	TxChainID = cli.StringFlag{
 		Name:  "chain-id",
@@ -65,6 +138,37 @@ var (
 		Value: "0x",
 	}
 
+	TxDenseFlag = cli.BoolFlag{
+		Name:  "dense",
+		Usage: "Pack 254 bits per field element instead of 31 bytes (~3% less blob gas)",
+	}
+
+	TxDataBlobsFlag = cli.IntFlag{
+		Name:  "data-blobs",
+		Usage: "Number of data blobs (k) per erasure coding stripe",
+		Value: 4,
+	}
+	TxParityBlobsFlag = cli.IntFlag{
+		Name:  "parity-blobs",
+		Usage: "Number of parity blobs (m) per erasure coding stripe. Any k of the k+m blobs are enough to rebuild the file. 0 disables erasure coding",
+		Value: 0,
+	}
+
+	TxCompressionFlag = cli.StringFlag{
+		Name:  "compression",
+		Usage: "Compress the file before splitting it in blobs (none, gzip or zstd)",
+		Value: "none",
+	}
+
+	TxRecipientFlag = cli.StringSliceFlag{
+		Name:  "recipient",
+		Usage: "Encrypt the file for this X25519 public key (hex). Can be repeated for multiple recipients",
+	}
+	TxConcurrencyFlag = cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Number of blobs whose KZG commitments and proofs are computed in parallel (default: number of CPUs)",
+	}
+
 	// With 6 blobs per tx you can upload 768KB every 12 seconds
 	MultiTxBlobsPerTx = cli.IntFlag{
 		Name:  "blobs-per-tx",
@@ -72,6 +176,12 @@ var (
 		Value: 6,
 	}
 
+	MultiTxMaxInFlightFlag = cli.IntFlag{
+		Name:  "max-in-flight",
+		Usage: "Blob transactions sent ahead without waiting for the previous ones to be included. Keep it within the per-account blob tx limit of the mempool (16 in geth). 1 waits for every transaction",
+		Value: 1,
+	}
+
 	DownloadBeaconRPCURLFlag = cli.StringFlag{
 		Name:  "rpc-url",
 		Usage: "Address of beacon node JSON-RPC endpoint",
@@ -82,20 +192,75 @@ var (
 		Usage: "Slot to download blob from",
 		Value: 125754,
 	}
+	DownloadIdentityFlag = cli.StringFlag{
+		Name:  "identity",
+		Usage: "File with the X25519 private key (hex) used to decrypt encrypted files",
+	}
+	DownloadPathFlag = cli.StringFlag{
+		Name:  "path",
+		Usage: "Only download this file of a directory upload",
+	}
+	DownloadOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "Directory where directory uploads are extracted (default: <slot>)",
+	}
+	DownloadCodecFlag = cli.StringFlag{
+		Name:  "codec",
+		Usage: "Only download blobs encoded with this codec (multipart, raw). With opstack, the channel frames of every OP Stack blob of the slot are written instead",
+	}
+
+	KeygenOutputFlag = cli.StringFlag{
+		Name:     "output",
+		Usage:    "File where the X25519 private key is written",
+		Required: true,
+	}
 
 	ProofBlobFileFlag = cli.StringFlag{
 		Name:     "blob-file",
-		Usage:    "Blob file data",
+		Usage:    "Blob file data, or - to read it from stdin",
 		Required: true,
 	}
-	ProofBlobIndexFlag = cli.StringFlag{
+	ProofBlobIndexFlag = cli.Uint64Flag{
 		Name:     "blob-index",
 		Usage:    "Blob index",
 		Required: true,
 	}
 	ProofInputPointFlag = cli.StringFlag{
 		Name:     "input-point",
-		Usage:    "Input point of the proof",
+		Usage:    "Input point of the proof (32 bytes hex, big-endian field element)",
+		Required: true,
+	}
+	ProofVerifyFlag = cli.BoolFlag{
+		Name:  "verify",
+		Usage: "Check the proof with the point evaluation precompile (0x0a) of go-ethereum",
+	}
+
+	TrustedSetupFlag = cli.StringFlag{
+		Name:  "trusted-setup",
+		Usage: "KZG trusted setup file (JSON or c-kzg text format) to use instead of the mainnet one, e.g. for devnets",
+	}
+
+	KZGBackendFlag = cli.StringFlag{
+		Name:  "kzg-backend",
+		Usage: "Library computing the KZG commitments and proofs (gokzg, or ckzg if built with -tags ckzg)",
+		Value: "gokzg",
+	}
+
+	BenchBlobsFlag = cli.IntFlag{
+		Name:  "blobs",
+		Usage: "Number of blobs to commit to and prove for each backend",
+		Value: 32,
+	}
+
+	BumpTxFlag = cli.StringFlag{
+		Name:     "tx",
+		Usage:    "Hash of the pending blob transaction to replace",
+		Required: true,
+	}
+
+	VerifyFileFlag = cli.StringFlag{
+		Name:     "file",
+		Usage:    "Sidecar bundle or beacon blob_sidecars response (JSON), or - to read it from stdin",
 		Required: true,
 	}
 )
@@ -106,28 +271,166 @@ var TxFlags = []cli.Flag{
 	TxToFlag,
 	TxValueFlag,
 	TxPrivateKeyFlag,
+	TxPrivateKeyEnvFlag,
+	TxKeystoreFlag,
+	TxMnemonicFileFlag,
+	TxDerivationPathFlag,
+	TxPasswordFileFlag,
+	TxSignerURLFlag,
+	TxSignerAddressFlag,
+	TxSignerMethodFlag,
 	TxNonceFlag,
 	TxGasLimitFlag,
 	TxGasPriceFlag,
 	TxPriorityGasPrice,
 	TxMaxFeePerBlobGas,
+	TxBlobFeeHeadroomFlag,
+	TxBumpAfterFlag,
+	TxBumpPercentFlag,
+	TxMaxBumpsFlag,
+	TxConfirmationsFlag,
+	TxReceiptTimeoutFlag,
+	TxJournalFlag,
+	TxResumeFlag,
 	TxChainID,
 	TxCalldata,
 	MultiTxBlobsPerTx,
+	MultiTxMaxInFlightFlag,
+	TxDenseFlag,
+	TxDataBlobsFlag,
+	TxParityBlobsFlag,
+	TxCompressionFlag,
+	TxRecipientFlag,
+	TxConcurrencyFlag,
+	TrustedSetupFlag,
+	KZGBackendFlag,
+}
+
+// Tx1Flags are the flags of tx1, which sends the file in a single transaction without the multipart encoding
+// options (encryption, compression, erasure coding) or the journal
+var Tx1Flags = []cli.Flag{
+	TxRPCURLFlag,
+	TxBlobFileFlag,
+	TxToFlag,
+	TxValueFlag,
+	TxPrivateKeyFlag,
+	TxPrivateKeyEnvFlag,
+	TxKeystoreFlag,
+	TxMnemonicFileFlag,
+	TxDerivationPathFlag,
+	TxPasswordFileFlag,
+	TxSignerURLFlag,
+	TxSignerAddressFlag,
+	TxSignerMethodFlag,
+	TxNonceFlag,
+	TxGasLimitFlag,
+	TxGasPriceFlag,
+	TxPriorityGasPrice,
+	TxMaxFeePerBlobGas,
+	TxBlobFeeHeadroomFlag,
+	TxBumpAfterFlag,
+	TxBumpPercentFlag,
+	TxMaxBumpsFlag,
+	TxConfirmationsFlag,
+	TxReceiptTimeoutFlag,
+	TxChainID,
+	TxCalldata,
+	TxDenseFlag,
+	TxConcurrencyFlag,
+	TrustedSetupFlag,
+	KZGBackendFlag,
 }
 
 var DownloadFlags = []cli.Flag{
 	DownloadBeaconRPCURLFlag,
 	DownloadSlotFlag,
+	DownloadIdentityFlag,
+	DownloadCodecFlag,
+	DownloadPathFlag,
+	DownloadOutputFlag,
 }
 
 var WebserverFlags = []cli.Flag{
 	TxRPCURLFlag,
 	TxPrivateKeyFlag,
+	TxPrivateKeyEnvFlag,
+	TxKeystoreFlag,
+	TxMnemonicFileFlag,
+	TxDerivationPathFlag,
+	TxPasswordFileFlag,
+	TxSignerURLFlag,
+	TxSignerAddressFlag,
+	TxSignerMethodFlag,
+	TxMaxFeePerBlobGas,
+	TxBlobFeeHeadroomFlag,
+	TxBumpAfterFlag,
+	TxBumpPercentFlag,
+	TxMaxBumpsFlag,
+	TxConfirmationsFlag,
+	TxReceiptTimeoutFlag,
+	TxDenseFlag,
+	TxDataBlobsFlag,
+	TxParityBlobsFlag,
+	TxCompressionFlag,
+	TxConcurrencyFlag,
+	TrustedSetupFlag,
+	KZGBackendFlag,
+}
+
+var KeygenFlags = []cli.Flag{
+	KeygenOutputFlag,
 }
 
 var ProofFlags = []cli.Flag{
 	ProofBlobFileFlag,
 	ProofBlobIndexFlag,
 	ProofInputPointFlag,
+	TxDenseFlag,
+	ProofVerifyFlag,
+	TrustedSetupFlag,
+	KZGBackendFlag,
+}
+
+var VerifyFlags = []cli.Flag{
+	VerifyFileFlag,
+	TrustedSetupFlag,
+	KZGBackendFlag,
+}
+
+var BenchFlags = []cli.Flag{
+	BenchBlobsFlag,
+	TxConcurrencyFlag,
+	TrustedSetupFlag,
+}
+
+var EstimateFlags = []cli.Flag{
+	TxRPCURLFlag,
+	TxBlobFileFlag,
+	TxGasLimitFlag,
+	TxGasPriceFlag,
+	TxPriorityGasPrice,
+	TxMaxFeePerBlobGas,
+	TxBlobFeeHeadroomFlag,
+	MultiTxBlobsPerTx,
+	TxDenseFlag,
+	TxDataBlobsFlag,
+	TxParityBlobsFlag,
+	TxCompressionFlag,
+	TxRecipientFlag,
+}
+
+var BumpFlags = []cli.Flag{
+	TxRPCURLFlag,
+	TxPrivateKeyFlag,
+	TxPrivateKeyEnvFlag,
+	TxKeystoreFlag,
+	TxMnemonicFileFlag,
+	TxDerivationPathFlag,
+	TxPasswordFileFlag,
+	TxSignerURLFlag,
+	TxSignerAddressFlag,
+	TxSignerMethodFlag,
+	BumpTxFlag,
+	TxBumpPercentFlag,
+	TxBlobFeeHeadroomFlag,
 }
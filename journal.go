@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Status of a transaction of the upload in the journal
+const (
+	journalSent      = "sent"
+	journalConfirmed = "confirmed"
+)
+
+// uploadJournal records the progress of a multipart upload so it can be resumed with the same seed, skipping the
+// transactions already confirmed. It is written to path after every change, or kept in memory if path is empty.
+type uploadJournal struct {
+	path string
+	mu   sync.Mutex
+
+	File        string        `json:"file"`
+	Seed        hexutil.Bytes `json:"seed"`
+	BlobsPerTx  int           `json:"blobsPerTx"`
+	Dense       bool          `json:"dense,omitempty"`
+	DataBlobs   int           `json:"dataBlobs,omitempty"`
+	ParityBlobs int           `json:"parityBlobs,omitempty"`
+	Compression string        `json:"compression"`
+	// Encrypted uploads cannot be resumed, their content key is not kept
+	Encrypted bool            `json:"encrypted,omitempty"`
+	Batches   []*journalBatch `json:"batches"`
+}
+
+// journalBatch is a transaction of the upload
+type journalBatch struct {
+	Index           int           `json:"index"`
+	VersionedHashes []common.Hash `json:"versionedHashes"`
+	Nonce           uint64        `json:"nonce"`
+	// Txs are the hashes of every version sent, replacements included
+	Txs         []common.Hash `json:"txs"`
+	Status      string        `json:"status"`
+	Block       uint64        `json:"block,omitempty"`
+	BlobGasUsed uint64        `json:"blobGasUsed,omitempty"`
+}
+
+// newUploadJournal starts the journal of an upload with a new seed
+func newUploadJournal(path string, params *BlobUploadParams) *uploadJournal {
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, uint64(time.Now().UnixNano()))
+	opts := params.EncodeOptions
+	return &uploadJournal{
+		path:        path,
+		File:        params.File,
+		Seed:        seed,
+		BlobsPerTx:  params.BlobsPerTx,
+		Dense:       opts.Dense,
+		DataBlobs:   opts.DataShards,
+		ParityBlobs: opts.ParityShards,
+		Compression: opts.Compression.String(),
+		Encrypted:   len(opts.Recipients) > 0,
+	}
+}
+
+// defaultJournalPath is the journal of an upload when --journal is not set
+func defaultJournalPath(seed []byte) string {
+	return fmt.Sprintf("blobtoss-%x.journal", seed)
+}
+
+func loadUploadJournal(path string) (*uploadJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	j := &uploadJournal{path: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("error decoding journal %s: %w", path, err)
+	}
+	if len(j.Seed) != 8 {
+		return nil, fmt.Errorf("journal %s has an invalid seed", path)
+	}
+	if j.Encrypted {
+		return nil, errors.New("encrypted uploads cannot be resumed, their content key is not kept")
+	}
+	return j, nil
+}
+
+// resume sets the encoding options of params to the ones of the journal, so the blobs are the same
+func (j *uploadJournal) resume(params *BlobUploadParams) error {
+	compression, err := parseCompression(j.Compression)
+	if err != nil {
+		return err
+	}
+	if params.File != j.File {
+		log.Printf("The journal was written for %s, resuming with %s", j.File, params.File)
+	}
+	params.BlobsPerTx = j.BlobsPerTx
+	params.EncodeOptions.Dense = j.Dense
+	params.EncodeOptions.DataShards = j.DataBlobs
+	params.EncodeOptions.ParityShards = j.ParityBlobs
+	params.EncodeOptions.Compression = compression
+	params.EncodeOptions.Recipients = nil
+	return nil
+}
+
+// batch returns a copy of the transaction at index, if it was sent
+func (j *uploadJournal) batch(index int) (journalBatch, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if b := j.find(index); b != nil {
+		return *b, true
+	}
+	return journalBatch{}, false
+}
+
+// pending returns the transactions sent but not confirmed
+func (j *uploadJournal) pending() []journalBatch {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var pending []journalBatch
+	for _, b := range j.Batches {
+		if b.Status != journalConfirmed {
+			pending = append(pending, *b)
+		}
+	}
+	return pending
+}
+
+// sent records the transaction tx carrying blobs
+func (j *uploadJournal) sent(blobs FullBlobStruct, tx *types.Transaction) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	b := j.find(blobs.Index)
+	if b == nil {
+		b = &journalBatch{Index: blobs.Index}
+		j.Batches = append(j.Batches, b)
+	}
+	b.VersionedHashes = blobs.VersionedHashes
+	b.Nonce = tx.Nonce()
+	b.Txs = append(b.Txs, tx.Hash())
+	b.Status = journalSent
+	return j.save()
+}
+
+// replaced records the replacement of the transaction old
+func (j *uploadJournal) replaced(old common.Hash, tx *types.Transaction) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	b := j.findTx(old)
+	if b == nil {
+		return fmt.Errorf("transaction %v is not in the journal", old)
+	}
+	b.Txs = append(b.Txs, tx.Hash())
+	return j.save()
+}
+
+// confirmed records the inclusion of a transaction
+func (j *uploadJournal) confirmed(receipt *types.Receipt) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	b := j.findTx(receipt.TxHash)
+	if b == nil {
+		return fmt.Errorf("transaction %v is not in the journal", receipt.TxHash)
+	}
+	b.Status = journalConfirmed
+	b.Block = receipt.BlockNumber.Uint64()
+	b.BlobGasUsed = receipt.BlobGasUsed
+	return j.save()
+}
+
+// blobGasUsed returns the blob gas of the confirmed transactions
+func (j *uploadJournal) blobGasUsed() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var total uint64
+	for _, b := range j.Batches {
+		total += b.BlobGasUsed
+	}
+	return total
+}
+
+func (j *uploadJournal) find(index int) *journalBatch {
+	for _, b := range j.Batches {
+		if b.Index == index {
+			return b
+		}
+	}
+	return nil
+}
+
+func (j *uploadJournal) findTx(hash common.Hash) *journalBatch {
+	for _, b := range j.Batches {
+		for _, tx := range b.Txs {
+			if tx == hash {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// save writes the journal to a temporary file renamed over the previous one, so a crash never leaves it truncated
+func (j *uploadJournal) save() error {
+	if j.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
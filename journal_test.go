@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestUploadJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.journal")
+	params := &BlobUploadParams{
+		File:          "file.bin",
+		BlobsPerTx:    3,
+		EncodeOptions: EncodeOptions{Dense: true, DataShards: 4, ParityShards: 2, Compression: CompressionZstd},
+	}
+	j := newUploadJournal(path, params)
+
+	hashes := []common.Hash{{1}, {2}, {3}}
+	tx := types.NewTx(&types.BlobTx{Nonce: 7})
+	replacement := types.NewTx(&types.BlobTx{Nonce: 7, Gas: 1})
+	if err := j.sent(FullBlobStruct{VersionedHashes: hashes, Index: 0}, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.replaced(tx.Hash(), replacement); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.confirmed(&types.Receipt{TxHash: replacement.Hash(), BlockNumber: big.NewInt(42), BlobGasUsed: 3 << 17}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.sent(FullBlobStruct{VersionedHashes: hashes[:1], Index: 1}, types.NewTx(&types.BlobTx{Nonce: 8})); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadUploadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Seed, j.Seed) {
+		t.Fatalf("expected seed %x, got %x", j.Seed, loaded.Seed)
+	}
+	first, ok := loaded.batch(0)
+	if !ok || first.Status != journalConfirmed || first.Block != 42 || first.Nonce != 7 || len(first.Txs) != 2 || !sameHashes(first.VersionedHashes, hashes) {
+		t.Fatalf("unexpected first transaction %+v", first)
+	}
+	if pending := loaded.pending(); len(pending) != 1 || pending[0].Index != 1 || pending[0].Nonce != 8 {
+		t.Fatalf("unexpected pending transactions %+v", pending)
+	}
+	if loaded.blobGasUsed() != 3<<17 {
+		t.Fatalf("unexpected blob gas %d", loaded.blobGasUsed())
+	}
+
+	// The options of the journal override the ones of the command
+	resumed := &BlobUploadParams{File: "file.bin", BlobsPerTx: 6}
+	if err := loaded.resume(resumed); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.BlobsPerTx != 3 || !resumed.EncodeOptions.Dense || resumed.EncodeOptions.DataShards != 4 ||
+		resumed.EncodeOptions.ParityShards != 2 || resumed.EncodeOptions.Compression != CompressionZstd {
+		t.Fatalf("unexpected resumed params %+v", resumed)
+	}
+}
+
+func TestUploadJournalEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.journal")
+	j := newUploadJournal(path, &BlobUploadParams{EncodeOptions: EncodeOptions{Recipients: [][]byte{make([]byte, 32)}}})
+	if err := j.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadUploadJournal(path); err == nil {
+		t.Fatal("expected encrypted uploads not to be resumable")
+	}
+}
+
+// A resumed upload encodes the same blobs when given the seed of the journal
+func TestBlobEncoderSeed(t *testing.T) {
+	data := makeBlob(2*chunkCapacity(EncodeOptions{}) + 100)
+	opts := EncodeOptions{Compression: CompressionGzip, Seed: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	encode := func() [][]byte {
+		enc, err := newBlobEncoder(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		var blobs [][]byte
+		for {
+			blob, err := enc.Next()
+			if err == io.EOF {
+				return blobs
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobs = append(blobs, blob[:])
+		}
+	}
+	first, second := encode(), encode()
+	if len(first) != len(second) {
+		t.Fatalf("expected %d blobs, got %d", len(first), len(second))
+	}
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("blob %d differs between encodings with the same seed", i)
+		}
+	}
+}
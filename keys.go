@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"github.com/urfave/cli"
+	"golang.org/x/term"
+)
+
+// readPrivateKey returns the key of the account sending the transactions. It is read from exactly one of
+// --private-key, --private-key-env, --keystore or --mnemonic-file.
+func readPrivateKey(cliCtx *cli.Context) (*ecdsa.PrivateKey, error) {
+	var sources []string
+	for _, flag := range []cli.StringFlag{TxPrivateKeyFlag, TxPrivateKeyEnvFlag, TxKeystoreFlag, TxMnemonicFileFlag} {
+		if cliCtx.String(flag.Name) != "" {
+			sources = append(sources, "--"+flag.Name)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no private key: use --keystore, --mnemonic-file, --private-key-env or --private-key")
+	}
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("only one private key can be used, got %s", strings.Join(sources, " and "))
+	}
+
+	switch {
+	case cliCtx.String(TxPrivateKeyFlag.Name) != "":
+		return parseHexKey(cliCtx.String(TxPrivateKeyFlag.Name))
+
+	case cliCtx.String(TxPrivateKeyEnvFlag.Name) != "":
+		name := cliCtx.String(TxPrivateKeyEnvFlag.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return parseHexKey(value)
+
+	case cliCtx.String(TxKeystoreFlag.Name) != "":
+		data, err := os.ReadFile(cliCtx.String(TxKeystoreFlag.Name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading keystore: %w", err)
+		}
+		passphrase, err := readPassphrase(cliCtx.String(TxPasswordFileFlag.Name), "Keystore passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		key, err := keystore.DecryptKey(data, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting keystore: %w", err)
+		}
+		return key.PrivateKey, nil
+
+	default:
+		data, err := os.ReadFile(cliCtx.String(TxMnemonicFileFlag.Name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading mnemonic: %w", err)
+		}
+		// The mnemonic has no passphrase unless --password-file is set
+		var passphrase string
+		if file := cliCtx.String(TxPasswordFileFlag.Name); file != "" {
+			if passphrase, err = readPassphrase(file, ""); err != nil {
+				return nil, err
+			}
+		}
+		path, err := accounts.ParseDerivationPath(cliCtx.String(TxDerivationPathFlag.Name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path: %w", err)
+		}
+		return mnemonicKey(string(data), passphrase, path)
+	}
+}
+
+// parseHexKey parses a hex private key, with or without 0x prefix
+func parseHexKey(s string) (*ecdsa.PrivateKey, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	key, err := crypto.HexToECDSA(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid private key", err)
+	}
+	return key, nil
+}
+
+// readPassphrase reads the first line of file, or asks it on the terminal with prompt if file is empty
+func readPassphrase(file string, prompt string) (string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading password file: %w", err)
+		}
+		return strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r"), nil
+	}
+
+	// The terminal is used even if stdin is the blob file
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+	if !term.IsTerminal(int(tty.Fd())) {
+		return "", errors.New("no terminal to ask the passphrase, use --password-file")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("error reading passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+// mnemonicKey derives the key at path (BIP-32) from a BIP-39 mnemonic and its passphrase
+func mnemonicKey(mnemonic string, passphrase string, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errors.New("invalid mnemonic")
+	}
+	return deriveKey(bip39.NewSeed(mnemonic, passphrase), path)
+}
+
+// deriveKey derives the private key at path from a BIP-32 seed
+func deriveKey(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	n := crypto.S256().Params().N
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := new(big.Int).SetBytes(sum[:32]), sum[32:]
+	if key.Sign() == 0 || key.Cmp(n) >= 0 {
+		return nil, errors.New("invalid master key")
+	}
+
+	for _, index := range path {
+		var data []byte
+		if index >= 0x80000000 {
+			// Hardened children are derived from the private key
+			data = append([]byte{0}, math.PaddedBigBytes(key, 32)...)
+		} else {
+			parent := crypto.ToECDSAUnsafe(math.PaddedBigBytes(key, 32))
+			data = crypto.CompressPubkey(&parent.PublicKey)
+		}
+		data = binary.BigEndian.AppendUint32(data, index)
+
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("invalid key at index %d, use another derivation path", index)
+		}
+		key = tweak.Add(tweak, key).Mod(tweak, n)
+		if key.Sign() == 0 {
+			return nil, fmt.Errorf("invalid key at index %d, use another derivation path", index)
+		}
+		chainCode = sum[32:]
+	}
+	return crypto.ToECDSA(math.PaddedBigBytes(key, 32))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/urfave/cli"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestMnemonicKey(t *testing.T) {
+	tests := []struct {
+		path    string
+		address common.Address
+	}{
+		{"m/44'/60'/0'/0/0", common.HexToAddress("0x9858EfFD232B4033E47d90003D41EC34EcaEda94")},
+		{"m/44'/60'/0'/0/1", common.HexToAddress("0x6Fac4D18c912343BF86fa7049364Dd4E424Ab9C0")},
+	}
+	for _, tt := range tests {
+		path, err := accounts.ParseDerivationPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := mnemonicKey(testMnemonic+"\n", "", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address := crypto.PubkeyToAddress(key.PublicKey); address != tt.address {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.address, address)
+		}
+	}
+
+	if _, err := mnemonicKey("abandon abandon abandon", "", accounts.DefaultBaseDerivationPath); err == nil {
+		t.Fatal("expected error for an invalid mnemonic")
+	}
+}
+
+// newKeyContext returns a context of the tx command with the given flags set
+func newKeyContext(t *testing.T, values map[string]string) *cli.Context {
+	set := flag.NewFlagSet("tx", flag.ContinueOnError)
+	for _, f := range []cli.StringFlag{TxPrivateKeyFlag, TxPrivateKeyEnvFlag, TxKeystoreFlag, TxMnemonicFileFlag, TxDerivationPathFlag, TxPasswordFileFlag} {
+		f.Apply(set)
+	}
+	for name, value := range values {
+		if err := set.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestReadPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	hexKey := common.Bytes2Hex(crypto.FromECDSA(key))
+
+	// Light scrypt parameters keep the test fast
+	data, err := keystore.EncryptKey(&keystore.Key{Id: uuid.New(), Address: address, PrivateKey: key}, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keystoreFile := filepath.Join(dir, "keystore.json")
+	passwordFile := filepath.Join(dir, "password.txt")
+	mnemonicFile := filepath.Join(dir, "mnemonic.txt")
+	for file, content := range map[string][]byte{keystoreFile: data, passwordFile: []byte("secret\n"), mnemonicFile: []byte(testMnemonic)} {
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("BLOBTOSS_TEST_KEY", "0x"+hexKey)
+
+	for name, values := range map[string]map[string]string{
+		"private key": {"private-key": hexKey},
+		"env":         {"private-key-env": "BLOBTOSS_TEST_KEY"},
+		"keystore":    {"keystore": keystoreFile, "password-file": passwordFile},
+	} {
+		got, err := readPrivateKey(newKeyContext(t, values))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if crypto.PubkeyToAddress(got.PublicKey) != address {
+			t.Fatalf("%s: got the key of %v", name, crypto.PubkeyToAddress(got.PublicKey))
+		}
+	}
+
+	got, err := readPrivateKey(newKeyContext(t, map[string]string{"mnemonic-file": mnemonicFile, "derivation-path": "m/44'/60'/0'/0/0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.PubkeyToAddress(got.PublicKey) != common.HexToAddress("0x9858EfFD232B4033E47d90003D41EC34EcaEda94") {
+		t.Fatalf("mnemonic: got the key of %v", crypto.PubkeyToAddress(got.PublicKey))
+	}
+
+	for name, values := range map[string]map[string]string{
+		"no key":         {},
+		"two keys":       {"private-key": hexKey, "private-key-env": "BLOBTOSS_TEST_KEY"},
+		"wrong password": {"keystore": keystoreFile, "password-file": mnemonicFile},
+		"unset env":      {"private-key-env": "BLOBTOSS_TEST_UNSET"},
+	} {
+		if _, err := readPrivateKey(newKeyContext(t, values)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/urfave/cli"
+)
+
+// kzgBackend computes and verifies the KZG commitments and proofs of blobs
+type kzgBackend interface {
+	BlobToCommitment(blob kzg4844.Blob) (kzg4844.Commitment, error)
+	ComputeBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment) (kzg4844.Proof, error)
+	ComputeProof(blob kzg4844.Blob, point kzg4844.Point) (kzg4844.Proof, kzg4844.Claim, error)
+	VerifyProof(commitment kzg4844.Commitment, point kzg4844.Point, claim kzg4844.Claim, proof kzg4844.Proof) error
+	VerifyBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment, proof kzg4844.Proof) error
+	VerifyBlobProofBatch(blobs []kzg4844.Blob, commitments []kzg4844.Commitment, proofs []kzg4844.Proof) error
+}
+
+// kzg is the backend used for all the KZG operations. It defaults to go-kzg with the mainnet trusted setup.
+var kzg kzgBackend = newGoKZG(nil)
+
+// customTrustedSetup is set when the mainnet trusted setup has been replaced with --trusted-setup
+var customTrustedSetup bool
+
+// kzgBackendNames are the backends that can be selected with --kzg-backend
+var kzgBackendNames = []string{"gokzg", "ckzg"}
+
+// newKZGBackend returns the backend called name with the given trusted setup (nil for the mainnet one)
+func newKZGBackend(name string, setup *gokzg4844.JSONTrustedSetup) (kzgBackend, error) {
+	switch name {
+	case "gokzg":
+		return newGoKZG(setup), nil
+	case "ckzg":
+		return newCKZG(setup)
+	default:
+		return nil, fmt.Errorf("unknown KZG backend %q, available backends: %s", name, strings.Join(kzgBackendNames, ", "))
+	}
+}
+
+// readTrustedSetupFlag returns the setup given with --trusted-setup, or nil for the mainnet one
+func readTrustedSetupFlag(cliCtx *cli.Context) (*gokzg4844.JSONTrustedSetup, error) {
+	filename := cliCtx.String(TrustedSetupFlag.Name)
+	if filename == "" {
+		return nil, nil
+	}
+	return loadTrustedSetup(filename)
+}
+
+// setupKZG applies the --kzg-backend and --trusted-setup flags
+func setupKZG(cliCtx *cli.Context) error {
+	setup, err := readTrustedSetupFlag(cliCtx)
+	if err != nil {
+		return err
+	}
+	backend, err := newKZGBackend(cliCtx.String(KZGBackendFlag.Name), setup)
+	if err != nil {
+		return err
+	}
+	kzg = backend
+	customTrustedSetup = setup != nil
+	return nil
+}
+
+// goKZG is the go-kzg-4844 backend. Its context is created on first use because loading the setup takes a while.
+type goKZG struct {
+	setup *gokzg4844.JSONTrustedSetup // nil for the mainnet setup
+
+	once sync.Once
+	ctx  *gokzg4844.Context
+	err  error
+}
+
+func newGoKZG(setup *gokzg4844.JSONTrustedSetup) *goKZG {
+	return &goKZG{setup: setup}
+}
+
+func (g *goKZG) context() (*gokzg4844.Context, error) {
+	g.once.Do(func() {
+		if g.setup == nil {
+			g.ctx, g.err = gokzg4844.NewContext4096Secure()
+		} else {
+			g.ctx, g.err = gokzg4844.NewContext4096(g.setup)
+		}
+	})
+	return g.ctx, g.err
+}
+
+func (g *goKZG) BlobToCommitment(blob kzg4844.Blob) (kzg4844.Commitment, error) {
+	ctx, err := g.context()
+	if err != nil {
+		return kzg4844.Commitment{}, err
+	}
+	// Blobs are already processed in parallel by computeBlobProofs
+	commitment, err := ctx.BlobToKZGCommitment(gokzg4844.Blob(blob), 1)
+	return kzg4844.Commitment(commitment), err
+}
+
+func (g *goKZG) ComputeBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment) (kzg4844.Proof, error) {
+	ctx, err := g.context()
+	if err != nil {
+		return kzg4844.Proof{}, err
+	}
+	proof, err := ctx.ComputeBlobKZGProof(gokzg4844.Blob(blob), gokzg4844.KZGCommitment(commitment), 1)
+	return kzg4844.Proof(proof), err
+}
+
+func (g *goKZG) ComputeProof(blob kzg4844.Blob, point kzg4844.Point) (kzg4844.Proof, kzg4844.Claim, error) {
+	ctx, err := g.context()
+	if err != nil {
+		return kzg4844.Proof{}, kzg4844.Claim{}, err
+	}
+	proof, claim, err := ctx.ComputeKZGProof(gokzg4844.Blob(blob), gokzg4844.Scalar(point), 0)
+	return kzg4844.Proof(proof), kzg4844.Claim(claim), err
+}
+
+func (g *goKZG) VerifyProof(commitment kzg4844.Commitment, point kzg4844.Point, claim kzg4844.Claim, proof kzg4844.Proof) error {
+	ctx, err := g.context()
+	if err != nil {
+		return err
+	}
+	return ctx.VerifyKZGProof(gokzg4844.KZGCommitment(commitment), gokzg4844.Scalar(point), gokzg4844.Scalar(claim), gokzg4844.KZGProof(proof))
+}
+
+func (g *goKZG) VerifyBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment, proof kzg4844.Proof) error {
+	ctx, err := g.context()
+	if err != nil {
+		return err
+	}
+	return ctx.VerifyBlobKZGProof(gokzg4844.Blob(blob), gokzg4844.KZGCommitment(commitment), gokzg4844.KZGProof(proof))
+}
+
+func (g *goKZG) VerifyBlobProofBatch(blobs []kzg4844.Blob, commitments []kzg4844.Commitment, proofs []kzg4844.Proof) error {
+	ctx, err := g.context()
+	if err != nil {
+		return err
+	}
+	var (
+		gBlobs       = make([]gokzg4844.Blob, len(blobs))
+		gCommitments = make([]gokzg4844.KZGCommitment, len(commitments))
+		gProofs      = make([]gokzg4844.KZGProof, len(proofs))
+	)
+	for i := range blobs {
+		gBlobs[i] = gokzg4844.Blob(blobs[i])
+	}
+	for i := range commitments {
+		gCommitments[i] = gokzg4844.KZGCommitment(commitments[i])
+	}
+	for i := range proofs {
+		gProofs[i] = gokzg4844.KZGProof(proofs[i])
+	}
+	return ctx.VerifyBlobKZGProofBatch(gBlobs, gCommitments, gProofs)
+}
+
+// kzgConcurrency returns the number of blobs whose commitments and proofs are computed in parallel
+func kzgConcurrency(concurrency int) int {
+	if concurrency < 1 {
+		return runtime.NumCPU()
+	}
+	return concurrency
+}
+
+// parallel calls fn for 0..n-1 with a pool of up to `concurrency` workers and returns the first error
+func parallel(n int, concurrency int, fn func(i int) error) error {
+	var (
+		errs = make([]error, n)
+		jobs = make(chan int)
+		wg   sync.WaitGroup
+	)
+
+	workers := kzgConcurrency(concurrency)
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// computeBlobProofs computes the commitments and proofs of the blobs with a pool of up to `concurrency` workers.
+// Results are in the same order as the blobs.
+func computeBlobProofs(blobs []kzg4844.Blob, concurrency int) ([]kzg4844.Commitment, []kzg4844.Proof, error) {
+	commits := make([]kzg4844.Commitment, len(blobs))
+	proofs := make([]kzg4844.Proof, len(blobs))
+	err := parallel(len(blobs), concurrency, func(i int) error {
+		var err error
+		if commits[i], err = kzg.BlobToCommitment(blobs[i]); err != nil {
+			return err
+		}
+		proofs[i], err = kzg.ComputeBlobProof(blobs[i], commits[i])
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return commits, proofs, nil
+}
+
+// newSidecar returns the sidecar of the blobs and their versioned hashes
+func newSidecar(blobs []kzg4844.Blob, concurrency int) (*types.BlobTxSidecar, []common.Hash, error) {
+	commits, proofs, err := computeBlobProofs(blobs, concurrency)
+	if err != nil {
+		return nil, nil, err
+	}
+	versionedHashes := make([]common.Hash, len(commits))
+	for i, commit := range commits {
+		versionedHashes[i] = kZGToVersionedHash(commit)
+	}
+	return &types.BlobTxSidecar{
+		Blobs:       blobs,
+		Commitments: commits,
+		Proofs:      proofs,
+	}, versionedHashes, nil
+}
@@ -0,0 +1,103 @@
+//go:build ckzg && cgo
+
+package main
+
+import (
+	"errors"
+	"sync"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+	ckzg4844 "github.com/ethereum/c-kzg-4844/bindings/go"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+// ckzgAvailable is true when blob-utils is built with c-kzg (go build -tags ckzg)
+const ckzgAvailable = true
+
+// c-kzg holds a single trusted setup per process, it is loaded by the first newCKZG call
+var (
+	ckzgOnce   sync.Once
+	ckzgErr    error
+	ckzgCustom bool
+)
+
+// cKZG is the c-kzg-4844 backend
+type cKZG struct{}
+
+func newCKZG(setup *gokzg4844.JSONTrustedSetup) (kzgBackend, error) {
+	ckzgOnce.Do(func() {
+		ckzgCustom = setup != nil
+		if setup == nil {
+			// go-ethereum loads its embedded mainnet setup in c-kzg
+			ckzgErr = kzg4844.UseCKZG(true)
+			return
+		}
+		var g1s, g2s []byte
+		for _, g1 := range setup.SetupG1Lagrange {
+			g1s = append(g1s, hexutil.MustDecode(g1)...)
+		}
+		for _, g2 := range setup.SetupG2 {
+			g2s = append(g2s, hexutil.MustDecode(g2)...)
+		}
+		ckzgErr = ckzg4844.LoadTrustedSetup(g1s, g2s)
+	})
+	if ckzgErr != nil {
+		return nil, ckzgErr
+	}
+	if ckzgCustom != (setup != nil) {
+		return nil, errors.New("c-kzg can only load one trusted setup per process")
+	}
+	return cKZG{}, nil
+}
+
+func (cKZG) BlobToCommitment(blob kzg4844.Blob) (kzg4844.Commitment, error) {
+	commitment, err := ckzg4844.BlobToKZGCommitment(ckzg4844.Blob(blob))
+	return kzg4844.Commitment(commitment), err
+}
+
+func (cKZG) ComputeBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment) (kzg4844.Proof, error) {
+	proof, err := ckzg4844.ComputeBlobKZGProof(ckzg4844.Blob(blob), ckzg4844.Bytes48(commitment))
+	return kzg4844.Proof(proof), err
+}
+
+func (cKZG) ComputeProof(blob kzg4844.Blob, point kzg4844.Point) (kzg4844.Proof, kzg4844.Claim, error) {
+	proof, claim, err := ckzg4844.ComputeKZGProof(ckzg4844.Blob(blob), ckzg4844.Bytes32(point))
+	return kzg4844.Proof(proof), kzg4844.Claim(claim), err
+}
+
+func (cKZG) VerifyProof(commitment kzg4844.Commitment, point kzg4844.Point, claim kzg4844.Claim, proof kzg4844.Proof) error {
+	return ckzgResult(ckzg4844.VerifyKZGProof(ckzg4844.Bytes48(commitment), ckzg4844.Bytes32(point), ckzg4844.Bytes32(claim), ckzg4844.Bytes48(proof)))
+}
+
+func (cKZG) VerifyBlobProof(blob kzg4844.Blob, commitment kzg4844.Commitment, proof kzg4844.Proof) error {
+	return ckzgResult(ckzg4844.VerifyBlobKZGProof(ckzg4844.Blob(blob), ckzg4844.Bytes48(commitment), ckzg4844.Bytes48(proof)))
+}
+
+func (cKZG) VerifyBlobProofBatch(blobs []kzg4844.Blob, commitments []kzg4844.Commitment, proofs []kzg4844.Proof) error {
+	var (
+		cBlobs       = make([]ckzg4844.Blob, len(blobs))
+		cCommitments = make([]ckzg4844.Bytes48, len(commitments))
+		cProofs      = make([]ckzg4844.Bytes48, len(proofs))
+	)
+	for i := range blobs {
+		cBlobs[i] = ckzg4844.Blob(blobs[i])
+	}
+	for i := range commitments {
+		cCommitments[i] = ckzg4844.Bytes48(commitments[i])
+	}
+	for i := range proofs {
+		cProofs[i] = ckzg4844.Bytes48(proofs[i])
+	}
+	return ckzgResult(ckzg4844.VerifyBlobKZGProofBatch(cBlobs, cCommitments, cProofs))
+}
+
+func ckzgResult(valid bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid proof")
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !ckzg || !cgo
+
+package main
+
+import (
+	"errors"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+)
+
+// ckzgAvailable is true when blob-utils is built with c-kzg (go build -tags ckzg)
+const ckzgAvailable = false
+
+func newCKZG(setup *gokzg4844.JSONTrustedSetup) (kzgBackend, error) {
+	return nil, errors.New("c-kzg is not available, build blob-utils with cgo and -tags ckzg")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+func TestComputeBlobProofsOrder(t *testing.T) {
+	blobs := encodeBlobs(makeBlob(5*rawBlobCapacity(headerFlagLength)), EncodeOptions{})
+	commits, proofs, err := computeBlobProofs(blobs, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, blob := range blobs {
+		commit, err := kzg4844.BlobToCommitment(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit != commits[i] {
+			t.Fatalf("commitment %d is out of order", i)
+		}
+		if err := kzg4844.VerifyBlobProof(blob, commit, proofs[i]); err != nil {
+			t.Fatalf("proof %d: %v", i, err)
+		}
+	}
+}
+
+// BenchmarkComputeBlobProofs measures the commitments and proofs of a transaction worth of blobs
+// (go test -bench ComputeBlobProofs -run ^$)
+func BenchmarkComputeBlobProofs(b *testing.B) {
+	blobs := encodeBlobs(makeBlob(6*rawBlobCapacity(headerFlagLength)), EncodeOptions{})
+	concurrencies := []int{1, 2, 4}
+	if runtime.NumCPU() > 4 {
+		concurrencies = append(concurrencies, runtime.NumCPU())
+	}
+	for _, concurrency := range concurrencies {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := computeBlobProofs(blobs, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPointEvaluationInput(t *testing.T) {
+	sidecar, versionedHashes, err := EncodeBlobs(makeBlob(1000), EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := kzg4844.Point{31: 5}
+	proof, y, err := kzg4844.ComputeProof(sidecar.Blobs[0], z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := pointEvaluationInput(versionedHashes[0], z, y, sidecar.Commitments[0], proof)
+	if len(input) != 192 {
+		t.Fatalf("expected 192 bytes, got %d", len(input))
+	}
+	if err := runPointEvaluation(input); err != nil {
+		t.Fatal(err)
+	}
+
+	y[31] ^= 1
+	if err := runPointEvaluation(pointEvaluationInput(versionedHashes[0], z, y, sidecar.Commitments[0], proof)); err == nil {
+		t.Fatal("expected the precompile to reject a wrong claim")
+	}
+}
+
+func TestKZGBackends(t *testing.T) {
+	blobs := randomBlobs(2)
+	commits, proofs, err := computeBlobProofs(blobs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range kzgBackendNames {
+		backend, err := newKZGBackend(name, nil)
+		if err != nil {
+			if name == "ckzg" && !ckzgAvailable {
+				continue
+			}
+			t.Fatal(err)
+		}
+		for i, blob := range blobs {
+			commit, err := backend.BlobToCommitment(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proof, err := backend.ComputeBlobProof(blob, commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit != commits[i] || proof != proofs[i] {
+				t.Fatalf("%s: blob %d has a different commitment or proof", name, i)
+			}
+		}
+		if err := backend.VerifyBlobProofBatch(blobs, commits, proofs); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if _, err := newKZGBackend("gnark", nil); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"os"
-	"time"
 
-	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/holiman/uint256"
 
@@ -30,11 +31,23 @@ func main() {
 			Flags:  TxFlags,
 		},
 
+		{
+			Name:   "estimate",
+			Usage:  "show the blobs, transactions, gas and cost of a multipart upload without sending it",
+			Action: EstimateApp,
+			Flags:  EstimateFlags,
+		},
+		{
+			Name:   "bump",
+			Usage:  "replace a pending blob transaction with higher fees",
+			Action: BumpApp,
+			Flags:  BumpFlags,
+		},
 		{
 			Name:   "tx1",
 			Usage:  "send a blob transaction",
 			Action: TxApp,
-			Flags:  TxFlags,
+			Flags:  Tx1Flags,
 		},
 		{
 			Name:   "download",
@@ -48,14 +61,30 @@ func main() {
 			Action: WebserverApp,
 			Flags:  WebserverFlags,
 		},
-		/*
-			{
-				Name:   "proof",
-				Usage:  "generate kzg proof for any input point by using jth blob polynomial",
-				Action: ProofApp,
-				Flags:  ProofFlags,
-			},
-		*/
+		{
+			Name:   "keygen",
+			Usage:  "generate a X25519 key pair to receive encrypted files",
+			Action: KeygenApp,
+			Flags:  KeygenFlags,
+		},
+		{
+			Name:   "proof",
+			Usage:  "generate kzg proof for any input point by using jth blob polynomial",
+			Action: ProofApp,
+			Flags:  ProofFlags,
+		},
+		{
+			Name:   "verify",
+			Usage:  "check the commitments, proofs and versioned hashes of a sidecar saved to disk",
+			Action: VerifyApp,
+			Flags:  VerifyFlags,
+		},
+		{
+			Name:   "bench",
+			Usage:  "measure the commitments and proofs per second of each KZG backend",
+			Action: BenchApp,
+			Flags:  BenchFlags,
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -67,7 +96,6 @@ func main() {
 func TxApp(cliCtx *cli.Context) error {
 	addr := cliCtx.String(TxRPCURLFlag.Name)
 	to := common.HexToAddress(cliCtx.String(TxToFlag.Name))
-	prv := cliCtx.String(TxPrivateKeyFlag.Name)
 	file := cliCtx.String(TxBlobFileFlag.Name)
 	nonce := cliCtx.Int64(TxNonceFlag.Name)
 	value := cliCtx.String(TxValueFlag.Name)
@@ -77,13 +105,27 @@ func TxApp(cliCtx *cli.Context) error {
 	maxFeePerBlobGas := cliCtx.String(TxMaxFeePerBlobGas.Name)
 	chainID := cliCtx.String(TxChainID.Name)
 	calldata := cliCtx.String(TxCalldata.Name)
+	dense := cliCtx.Bool(TxDenseFlag.Name)
+
+	if err := setupKZG(cliCtx); err != nil {
+		return err
+	}
+	signer, err := readSigner(context.Background(), cliCtx)
+	if err != nil {
+		return err
+	}
 
 	value256, err := uint256.FromHex(value)
 	if err != nil {
 		return fmt.Errorf("invalid value param: %v", err)
 	}
 
-	data, err := os.ReadFile(file)
+	blobFile, err := openBlobFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading blob file: %v", err)
+	}
+	data, err := io.ReadAll(blobFile)
+	blobFile.Close()
 	if err != nil {
 		return fmt.Errorf("error reading blob file: %v", err)
 	}
@@ -96,13 +138,8 @@ func TxApp(cliCtx *cli.Context) error {
 		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
 
-	key, err := crypto.HexToECDSA(prv)
-	if err != nil {
-		return fmt.Errorf("%w: invalid private key", err)
-	}
-
 	if nonce == -1 {
-		pendingNonce, err := client.PendingNonceAt(ctx, crypto.PubkeyToAddress(key.PublicKey))
+		pendingNonce, err := client.PendingNonceAt(ctx, signer.Address())
 		if err != nil {
 			log.Fatalf("Error getting nonce in main: %v", err)
 		}
@@ -135,12 +172,12 @@ func TxApp(cliCtx *cli.Context) error {
 		}
 	}
 
-	maxFeePerBlobGas256, err := DecodeUint256String(maxFeePerBlobGas)
+	maxFeePerBlobGas256, err := resolveMaxFeePerBlobGas(ctx, client, maxFeePerBlobGas, cliCtx.Float64(TxBlobFeeHeadroomFlag.Name))
 	if err != nil {
-		return fmt.Errorf("%w: invalid max_fee_per_blob_gas", err)
+		return err
 	}
 
-	sidecar, versionedHashes, err := EncodeBlobs(data)
+	sidecar, versionedHashes, err := EncodeBlobs(data, EncodeOptions{Dense: dense, Concurrency: cliCtx.Int(TxConcurrencyFlag.Name)})
 	if err != nil {
 		log.Fatalf("failed to compute commitments: %v", err)
 	}
@@ -150,7 +187,8 @@ func TxApp(cliCtx *cli.Context) error {
 		log.Fatalf("failed to parse calldata: %v", err)
 	}
 
-	tx := types.NewTx(&types.BlobTx{
+	bumpPercent := cliCtx.Uint64(TxBumpPercentFlag.Name)
+	signedTx, err := sendBlobTxBumping(ctx, client, signer, chainId, &types.BlobTx{
 		ChainID:    uint256.MustFromBig(chainId),
 		Nonce:      uint64(nonce),
 		GasTipCap:  priorityGasPrice256,
@@ -162,90 +200,132 @@ func TxApp(cliCtx *cli.Context) error {
 		BlobFeeCap: maxFeePerBlobGas256,
 		BlobHashes: versionedHashes,
 		Sidecar:    sidecar,
-	})
-	signedTx, err := types.SignTx(tx, types.NewCancunSigner(chainId), key)
+	}, bumpPercent)
 	if err != nil {
-		log.Fatalf("failed to sign tx: %v", err)
+		log.Fatalf("failed to send transaction: %v", err)
 	}
+	log.Printf("successfully sent transaction. Check https://blobscan.com/tx/%v", signedTx.Hash())
 
-	rlpData, err := signedTx.MarshalBinary()
+	waiter := &receiptWaiter{
+		client:        client,
+		timeout:       cliCtx.Duration(TxReceiptTimeoutFlag.Name),
+		confirmations: cliCtx.Uint64(TxConfirmationsFlag.Name),
+		bumpAfter:     cliCtx.Uint64(TxBumpAfterFlag.Name),
+		maxBumps:      cliCtx.Int(TxMaxBumpsFlag.Name),
+		bump: func(tx *types.Transaction) (*types.Transaction, error) {
+			return replaceBlobTx(ctx, client, signer, tx, bumpPercent, cliCtx.Float64(TxBlobFeeHeadroomFlag.Name))
+		},
+	}
+	receipt, err := waiter.wait(ctx, signedTx)
 	if err != nil {
-		log.Fatalf("failed to marshal tx: %v", err)
+		return err
 	}
 
-	err = client.Client().CallContext(context.Background(), nil, "eth_sendRawTransaction", hexutil.Encode(rlpData))
+	log.Printf("Transaction confirmed. nonce=%d hash=%v, block=%d", nonce, receipt.TxHash, receipt.BlockNumber.Int64())
+	return nil
+}
+
+func KeygenApp(cliCtx *cli.Context) error {
+	output := cliCtx.String(KeygenOutputFlag.Name)
 
+	identity, recipient, err := newIdentity()
 	if err != nil {
-		log.Fatalf("failed to send transaction: %v", err)
-	} else {
-		log.Printf("successfully sent transaction. Check https://blobscan.com/tx/%v", signedTx.Hash())
-	}
-
-	var receipt *types.Receipt
-
-	for {
-		receipt, err = client.TransactionReceipt(context.Background(), signedTx.Hash())
-		if err == ethereum.NotFound {
-			time.Sleep(1 * time.Second)
-		} else if err != nil {
-			if _, ok := err.(*json.UnmarshalTypeError); ok {
-				// TODO: ignore other errors for now. Some clients are treating the blobGasUsed as big.Int rather than uint64
-				break
-			}
-		} else {
-			break
-		}
+		return err
+	}
+	if err := os.WriteFile(output, []byte(hex.EncodeToString(identity)+"\n"), 0600); err != nil {
+		return fmt.Errorf("error writing identity file: %v", err)
 	}
 
-	log.Printf("Transaction included. nonce=%d hash=%v, block=%d", nonce, tx.Hash(), receipt.BlockNumber.Int64())
+	fmt.Printf("Identity written to %s\n", output)
+	fmt.Printf("Public key (use it with --recipient): %x\n", recipient)
 	return nil
 }
 
-/*
+// pointEvaluationPrecompile is the address of the point evaluation precompile (EIP-4844)
+var pointEvaluationPrecompile = common.BytesToAddress([]byte{0x0a})
+
+// pointEvaluationInput packs the 192 bytes input of the point evaluation precompile
+func pointEvaluationInput(versionedHash common.Hash, z kzg4844.Point, y kzg4844.Claim, commitment kzg4844.Commitment, proof kzg4844.Proof) []byte {
+	return bytes.Join(
+		[][]byte{
+			versionedHash[:],
+			z[:],
+			y[:],
+			commitment[:],
+			proof[:],
+		},
+		[]byte{},
+	)
+}
+
+// runPointEvaluation runs go-ethereum's implementation of the point evaluation precompile
+func runPointEvaluation(input []byte) error {
+	_, err := vm.PrecompiledContractsCancun[pointEvaluationPrecompile].Run(input)
+	return err
+}
+
+// ProofApp computes the KZG proof of the polynomial of a blob at an input point, as the blobs of the file would be
+// sent by tx1
 func ProofApp(cliCtx *cli.Context) error {
 	file := cliCtx.String(ProofBlobFileFlag.Name)
 	blobIndex := cliCtx.Uint64(ProofBlobIndexFlag.Name)
 	inputPoint := cliCtx.String(ProofInputPointFlag.Name)
+	dense := cliCtx.Bool(TxDenseFlag.Name)
+
+	if err := setupKZG(cliCtx); err != nil {
+		return err
+	}
+	if customTrustedSetup && cliCtx.Bool(ProofVerifyFlag.Name) {
+		return fmt.Errorf("--verify uses the point evaluation precompile of go-ethereum, which only supports the mainnet trusted setup")
+	}
 
-	data, err := os.ReadFile(file)
+	blobFile, err := openBlobFile(file)
 	if err != nil {
 		return fmt.Errorf("error reading blob file: %v", err)
 	}
-	blobs, commitments, _, versionedHashes, err := EncodeBlobs(data)
+	data, err := io.ReadAll(blobFile)
+	blobFile.Close()
 	if err != nil {
-		log.Fatalf("failed to compute commitments: %v", err)
+		return fmt.Errorf("error reading blob file: %v", err)
+	}
+	sidecar, versionedHashes, err := EncodeBlobs(data, EncodeOptions{Dense: dense})
+	if err != nil {
+		return fmt.Errorf("failed to compute commitments: %v", err)
 	}
 
-	if blobIndex >= uint64(len(blobs)) {
-		return fmt.Errorf("error reading %d blob", blobIndex)
+	if blobIndex >= uint64(len(sidecar.Blobs)) {
+		return fmt.Errorf("blob index %d out of range, the file has %d blobs", blobIndex, len(sidecar.Blobs))
 	}
 
-	if len(inputPoint) != 64 {
-		return fmt.Errorf("wrong input point, len is %d", len(inputPoint))
+	ip, err := hexutil.Decode(inputPoint)
+	if err != nil {
+		ip, err = hex.DecodeString(inputPoint)
 	}
+	if err != nil || len(ip) != 32 {
+		return fmt.Errorf("wrong input point, expected 32 bytes hex")
+	}
+	var z kzg4844.Point
+	copy(z[:], ip)
 
-	ctx, _ := gokzg4844.NewContext4096Secure()
-	var x gokzg4844.Scalar
-	ip, _ := hex.DecodeString(inputPoint)
-	copy(x[:], ip)
-	proof, claimedValue, err := ctx.ComputeKZGProof(gokzg4844.Blob(blobs[blobIndex]), x, 0)
+	proof, y, err := kzg.ComputeProof(sidecar.Blobs[blobIndex], z)
 	if err != nil {
-		log.Fatalf("failed to compute proofs: %v", err)
+		return fmt.Errorf("failed to compute proof: %v", err)
 	}
 
-	pointEvalInput := bytes.Join(
-		[][]byte{
-			versionedHashes[blobIndex][:],
-			x[:],
-			claimedValue[:],
-			commitments[blobIndex][:],
-			proof[:],
-		},
-		[]byte{},
-	)
-	log.Printf(
-		"\nversionedHash %x \n"+"x %x \n"+"y %x \n"+"commitment %x \n"+"proof %x \n"+"pointEvalInput %x",
-		versionedHashes[blobIndex][:], x[:], claimedValue[:], commitments[blobIndex][:], proof[:], pointEvalInput[:])
+	commitment := sidecar.Commitments[blobIndex]
+	pointEvalInput := pointEvaluationInput(versionedHashes[blobIndex], z, y, commitment, proof)
+	fmt.Printf("versionedHash  %x\n", versionedHashes[blobIndex][:])
+	fmt.Printf("z              %x\n", z[:])
+	fmt.Printf("y              %x\n", y[:])
+	fmt.Printf("commitment     %x\n", commitment[:])
+	fmt.Printf("proof          %x\n", proof[:])
+	fmt.Printf("pointEvalInput %x\n", pointEvalInput)
+
+	if cliCtx.Bool(ProofVerifyFlag.Name) {
+		if err := runPointEvaluation(pointEvalInput); err != nil {
+			return cli.NewExitError(fmt.Sprintf("point evaluation precompile rejected the proof: %v", err), 1)
+		}
+		fmt.Println("Proof verified by the point evaluation precompile")
+	}
 	return nil
 }
-*/
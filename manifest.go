@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Manifest is stored in the first blob of a multipart file (flagged with headerFlagManifest), followed by the blobs
+// of its chunk digests for big files.
+// It allows checking that the downloaded chunks form the original file.
+type Manifest struct {
+	FileSize uint64      `json:"fileSize"`
+	SHA256   common.Hash `json:"sha256"`
+	Chunks   int         `json:"chunks"`
+	// ChunkSize is the size of every chunk but the last one
+	ChunkSize int `json:"chunkSize,omitempty"`
+	// ChunkDigests contains the SHA-256 of the payload of every chunk, in order
+	ChunkDigests []common.Hash `json:"chunkDigests"`
+	// DigestBlobs is set when there are too many chunks for their digests to fit in the manifest. They are then
+	// stored in binary in the DigestBlobs blobs following it instead.
+	DigestBlobs int `json:"digestBlobs,omitempty"`
+	// Encryption is only set when the file is encrypted. FileSize and digests are computed on the encrypted file.
+	Encryption *Encryption `json:"encryption,omitempty"`
+	// Filename and ContentType of the original file, used when serving it
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	// Archive is the index of the files of a directory upload
+	Archive []ArchiveEntry `json:"archive,omitempty"`
+}
+
+// readManifest computes the manifest of a file read from r, split in chunks of chunkSize bytes
+func readManifest(r io.Reader, chunkSize int) (*Manifest, error) {
+	manifest := &Manifest{ChunkSize: chunkSize}
+	hasher := sha256.New()
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		// Empty files still have one empty chunk
+		if n > 0 || manifest.Chunks == 0 {
+			hasher.Write(chunk[:n])
+			manifest.FileSize += uint64(n)
+			manifest.Chunks++
+			manifest.ChunkDigests = append(manifest.ChunkDigests, sha256.Sum256(chunk[:n]))
+		}
+		if n < chunkSize {
+			break
+		}
+	}
+	manifest.SHA256 = common.BytesToHash(hasher.Sum(nil))
+	return manifest, nil
+}
+
+// manifestDigestSize is the size a digest takes in the JSON of the manifest, quotes and comma included
+const manifestDigestSize = 2 + 2*common.HashLength + 3
+
+// digestBlobs returns the number of blobs needed after the manifest to hold the digests of chunks, 0 if they fit in it
+func digestBlobs(chunks int, capacity int) int {
+	if chunks*manifestDigestSize < capacity-1024 {
+		return 0
+	}
+	perBlob := capacity / common.HashLength
+	return (chunks + perBlob - 1) / perBlob
+}
+
+// encodeManifest returns the payloads of the manifest: the manifest itself, then the chunk digests if they do not fit
+func encodeManifest(manifest *Manifest, capacity int) ([][]byte, error) {
+	stored := *manifest
+	var digests [][]byte
+	if blobs := digestBlobs(manifest.Chunks, capacity); blobs > 0 {
+		perBlob := capacity / common.HashLength
+		for start := 0; start < manifest.Chunks; start += perBlob {
+			end := start + perBlob
+			if end > manifest.Chunks {
+				end = manifest.Chunks
+			}
+			payload := make([]byte, 0, (end-start)*common.HashLength)
+			for _, digest := range manifest.ChunkDigests[start:end] {
+				payload = append(payload, digest[:]...)
+			}
+			digests = append(digests, payload)
+		}
+		stored.ChunkDigests = nil
+		stored.DigestBlobs = blobs
+	}
+
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return nil, err
+	}
+	// The rest of the manifest, e.g. the index of a directory upload, is never split
+	if len(data) > capacity {
+		return nil, fmt.Errorf("manifest needs %d bytes but a blob fits %d, upload fewer files or files with shorter names", len(data), capacity)
+	}
+	return append([][]byte{data}, digests...), nil
+}
+
+func decodeManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.DigestBlobs > 0 {
+		if len(manifest.ChunkDigests) != 0 {
+			return nil, errors.New("invalid manifest: chunk digests are both in the manifest and in blobs")
+		}
+	} else if manifest.Chunks != len(manifest.ChunkDigests) {
+		return nil, fmt.Errorf("invalid manifest: %d chunks but %d digests", manifest.Chunks, len(manifest.ChunkDigests))
+	}
+	return &manifest, nil
+}
+
+// addDigests adds the chunk digests stored in a blob following the manifest
+func (m *Manifest) addDigests(payload []byte) error {
+	if len(payload) == 0 || len(payload)%common.HashLength != 0 || len(m.ChunkDigests)+len(payload)/common.HashLength > m.Chunks {
+		return fmt.Errorf("invalid manifest: blob of %d bytes of chunk digests", len(payload))
+	}
+	for i := 0; i < len(payload); i += common.HashLength {
+		m.ChunkDigests = append(m.ChunkDigests, common.BytesToHash(payload[i:i+common.HashLength]))
+	}
+	return nil
+}
+
+// complete reports whether the digests of all the chunks are known
+func (m *Manifest) complete() bool {
+	return len(m.ChunkDigests) == m.Chunks
+}
+
+// manifestVerifier checks the chunks of a file against its manifest as they are downloaded
+type manifestVerifier struct {
+	manifest *Manifest
+	hasher   hash.Hash
+	size     uint64
+	chunks   int
+}
+
+func newManifestVerifier(manifest *Manifest) *manifestVerifier {
+	return &manifestVerifier{
+		manifest: manifest,
+		hasher:   sha256.New(),
+	}
+}
+
+// Write checks the digest of the next chunk
+func (v *manifestVerifier) Write(chunk []byte) error {
+	if v.chunks >= v.manifest.Chunks {
+		return fmt.Errorf("integrity check failed: manifest contains %d chunks, got more", v.manifest.Chunks)
+	}
+	if digest := sha256.Sum256(chunk); common.Hash(digest) != v.manifest.ChunkDigests[v.chunks] {
+		return fmt.Errorf("integrity check failed: chunk %d has digest %x, expected %x", v.chunks, digest, v.manifest.ChunkDigests[v.chunks])
+	}
+
+	v.hasher.Write(chunk)
+	v.size += uint64(len(chunk))
+	v.chunks++
+	return nil
+}
+
+// Verify checks the whole file once all the chunks have been written
+func (v *manifestVerifier) Verify() error {
+	if v.chunks != v.manifest.Chunks {
+		return fmt.Errorf("integrity check failed: got %d chunks, expected %d", v.chunks, v.manifest.Chunks)
+	}
+	if v.size != v.manifest.FileSize {
+		return fmt.Errorf("integrity check failed: got %d bytes, expected %d", v.size, v.manifest.FileSize)
+	}
+	if digest := common.BytesToHash(v.hasher.Sum(nil)); digest != v.manifest.SHA256 {
+		return fmt.Errorf("integrity check failed: file has SHA-256 %x, expected %x", digest, v.manifest.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// OP Stack batchers encode their data in rounds of 4 field elements. The first byte of each field element only
+// uses its low 6 bits, so the 4 first bytes of a round carry 3 extra bytes of data (127 bytes per round).
+// The first field element holds the encoding version (byte 1) and the length of the data (bytes 2 to 4).
+const (
+	opStackEncodingVersion = 0
+	opStackRounds          = params.BlobTxFieldElementsPerBlob / 4
+	opStackMaxDataSize     = (4*31+3)*opStackRounds - 4
+
+	// Batcher data starts with the derivation version, followed by the channel frames
+	opStackDerivationVersion = 0
+	opStackFrameOverhead     = 16 + 2 + 4 + 1
+)
+
+type opStackFrame struct {
+	ChannelID   [16]byte
+	FrameNumber uint16
+	Data        []byte
+	IsLast      bool
+}
+
+// encodeOPStackBlob encodes up to opStackMaxDataSize bytes the way the OP Stack batcher does
+func encodeOPStackBlob(data []byte) (kzg4844.Blob, error) {
+	var blob kzg4844.Blob
+	if len(data) > opStackMaxDataSize {
+		return blob, fmt.Errorf("too much data to encode in one blob: %d bytes, max: %d", len(data), opStackMaxDataSize)
+	}
+
+	readOffset := 0
+	read1 := func() byte {
+		if readOffset >= len(data) {
+			return 0
+		}
+		b := data[readOffset]
+		readOffset++
+		return b
+	}
+	var buf [31]byte
+	read31 := func() {
+		buf = [31]byte{}
+		if readOffset < len(data) {
+			readOffset += copy(buf[:], data[readOffset:])
+		}
+	}
+	writeOffset := 0
+	write := func(first byte) {
+		blob[writeOffset] = first
+		copy(blob[writeOffset+1:writeOffset+32], buf[:])
+		writeOffset += 32
+	}
+
+	for round := 0; round < opStackRounds && readOffset < len(data); round++ {
+		if round == 0 {
+			buf = [31]byte{}
+			buf[0] = opStackEncodingVersion
+			buf[1] = byte(len(data) >> 16)
+			buf[2] = byte(len(data) >> 8)
+			buf[3] = byte(len(data))
+			readOffset += copy(buf[4:], data)
+		} else {
+			read31()
+		}
+		x := read1()
+		write(x & 0b0011_1111)
+
+		read31()
+		y := read1()
+		write((y & 0b0000_1111) | (x&0b1100_0000)>>2)
+
+		read31()
+		z := read1()
+		write(z & 0b0011_1111)
+
+		read31()
+		write((z&0b1100_0000)>>2 | (y&0b1111_0000)>>4)
+	}
+	return blob, nil
+}
+
+// decodeOPStackBlob returns the data of a blob encoded by an OP Stack batcher
+func decodeOPStackBlob(blob []byte) ([]byte, error) {
+	if len(blob) != params.BlobTxFieldElementsPerBlob*32 {
+		return nil, fmt.Errorf("invalid blob length %d", len(blob))
+	}
+	if blob[1] != opStackEncodingVersion {
+		return nil, fmt.Errorf("unsupported encoding version %d", blob[1])
+	}
+	length := int(blob[2])<<16 | int(blob[3])<<8 | int(blob[4])
+	if length > opStackMaxDataSize {
+		return nil, fmt.Errorf("invalid data length: %d, max: %d", length, opStackMaxDataSize)
+	}
+
+	output := make([]byte, opStackMaxDataSize)
+	var first [4]byte
+	outputOffset, inputOffset := 0, 0
+	for round := 0; round < opStackRounds; round++ {
+		for i := 0; i < 4; i++ {
+			first[i] = blob[inputOffset]
+			if first[i]&0b1100_0000 != 0 {
+				return nil, fmt.Errorf("field element %d: highest bits are set", inputOffset/32)
+			}
+			if round == 0 && i == 0 {
+				// Skip the version and length
+				outputOffset += copy(output, blob[5:32]) + 1
+			} else {
+				outputOffset += copy(output[outputOffset:], blob[inputOffset+1:inputOffset+32]) + 1
+			}
+			inputOffset += 32
+		}
+		// The last byte of the round is not used, so x, y and z fill the gaps after the first 3 field elements
+		outputOffset--
+		output[outputOffset-32] = first[2]&0b0011_1111 | (first[3]&0b0011_0000)<<2
+		output[outputOffset-64] = first[1]&0b0000_1111 | (first[3]&0b0000_1111)<<4
+		output[outputOffset-96] = first[0]&0b0011_1111 | (first[1]&0b0011_0000)<<2
+	}
+
+	for i := length; i < len(output); i++ {
+		if output[i] != 0 {
+			return nil, fmt.Errorf("non-zero data after the end of the blob at byte %d", i)
+		}
+	}
+	return output[:length], nil
+}
+
+// parseOPStackFrames splits batcher data in channel frames
+func parseOPStackFrames(data []byte) ([]opStackFrame, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty batcher data")
+	}
+	if data[0] != opStackDerivationVersion {
+		return nil, fmt.Errorf("unsupported derivation version %d", data[0])
+	}
+	data = data[1:]
+
+	var frames []opStackFrame
+	for len(data) > 0 {
+		if len(data) < opStackFrameOverhead {
+			return nil, fmt.Errorf("frame %d: truncated header", len(frames))
+		}
+		var frame opStackFrame
+		copy(frame.ChannelID[:], data[:16])
+		frame.FrameNumber = binary.BigEndian.Uint16(data[16:18])
+		frameLength := int(binary.BigEndian.Uint32(data[18:22]))
+		if frameLength > len(data)-opStackFrameOverhead {
+			return nil, fmt.Errorf("frame %d: invalid length %d", len(frames), frameLength)
+		}
+		frame.Data = data[22 : 22+frameLength]
+		switch data[22+frameLength] {
+		case 0:
+		case 1:
+			frame.IsLast = true
+		default:
+			return nil, fmt.Errorf("frame %d: invalid is_last byte %d", len(frames), data[22+frameLength])
+		}
+		frames = append(frames, frame)
+		data = data[opStackFrameOverhead+frameLength:]
+	}
+	return frames, nil
+}
+
+// opStackCodec reads the blobs posted by OP Stack batchers. Each blob contains channel frames.
+type opStackCodec struct{}
+
+func (opStackCodec) Name() string { return "opstack" }
+
+// Encode splits data in blobs like a batcher would. Encode options do not apply to this format.
+func (opStackCodec) Encode(data []byte, opts EncodeOptions) ([]kzg4844.Blob, error) {
+	var blobs []kzg4844.Blob
+	// There is always at least one blob, even for empty data
+	for start := 0; start == 0 || start < len(data); start += opStackMaxDataSize {
+		end := start + opStackMaxDataSize
+		if end > len(data) {
+			end = len(data)
+		}
+		blob, err := encodeOPStackBlob(data[start:end])
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
+
+func (opStackCodec) Decode(blob []byte) (*MagicHeader, []byte, error) {
+	data, err := decodeOPStackBlob(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &MagicHeader{TotalBlobs: 1, Length: len(data)}, data, nil
+}
+
+// Detect only accepts blobs that decode to valid frames
+func (opStackCodec) Detect(blob []byte) bool {
+	data, err := decodeOPStackBlob(blob)
+	if err != nil {
+		return false
+	}
+	_, err = parseOPStackFrames(data)
+	return err == nil
+}
+
+// WriteBatch prints the frames of a blob and writes the data of each one to <slot>-<index>-<channel>-<frame>.bin
+func (opStackCodec) WriteBatch(slot, index int, data []byte) error {
+	frames, err := parseOPStackFrames(data)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		fmt.Printf("[SLOT %d] Blob %d: channel %x frame %d with %d bytes (last: %t)\n", slot, index, frame.ChannelID, frame.FrameNumber, len(frame.Data), frame.IsLast)
+
+		filename := fmt.Sprintf("%d-%d-%x-%d.bin", slot, index, frame.ChannelID, frame.FrameNumber)
+		if err := os.WriteFile(filename, frame.Data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Frame data written to '%s' successfully.\n", filename)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func makeOPStackFrame(channel byte, number uint16, data []byte, last bool) []byte {
+	frame := make([]byte, opStackFrameOverhead+len(data))
+	frame[0] = channel
+	binary.BigEndian.PutUint16(frame[16:], number)
+	binary.BigEndian.PutUint32(frame[18:], uint32(len(data)))
+	copy(frame[22:], data)
+	if last {
+		frame[len(frame)-1] = 1
+	}
+	return frame
+}
+
+func TestOPStackCodec(t *testing.T) {
+	codec := opStackCodec{}
+	first := makeOPStackFrame(1, 0, makeBlob(100), false)
+	second := makeOPStackFrame(1, 1, makeBlob(opStackMaxDataSize-1-len(first)-opStackFrameOverhead), true)
+	data := append(append([]byte{opStackDerivationVersion}, first...), second...)
+
+	for _, data := range [][]byte{data[:1+len(first)], data} {
+		blobs, err := codec.Encode(data, EncodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blobs) != 1 {
+			t.Fatalf("expected 1 blob, got %d", len(blobs))
+		}
+		blob := blobs[0][:]
+		if blob[1] != opStackEncodingVersion || int(blob[2])<<16|int(blob[3])<<8|int(blob[4]) != len(data) || !bytes.Equal(blob[5:32], data[:27]) {
+			t.Fatalf("unexpected first field element %x", blob[:32])
+		}
+		if detected := detectCodec(blob, codecs); detected != codec {
+			t.Fatalf("opstack blob detected as %v", detected)
+		}
+
+		_, dec, err := codec.Decode(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, dec) {
+			t.Fatalf("round trip failed: expected %d bytes, got %d", len(data), len(dec))
+		}
+		frames, err := parseOPStackFrames(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := frames[len(frames)-1]
+		if last.ChannelID[0] != 1 || int(last.FrameNumber) != len(frames)-1 || last.IsLast != (len(frames) == 2) {
+			t.Fatalf("unexpected frame %+v", last)
+		}
+
+		blob[32] |= 0b1000_0000
+		if _, _, err := codec.Decode(blob); err == nil {
+			t.Fatal("expected error decoding blob with invalid field element")
+		}
+	}
+
+	if _, err := encodeOPStackBlob(make([]byte, opStackMaxDataSize+1)); err == nil {
+		t.Fatal("expected error encoding too much data")
+	}
+	if _, err := parseOPStackFrames(data[:len(data)-1]); err == nil {
+		t.Fatal("expected error parsing truncated frames")
+	}
+}
+
+// One full round of the blob encoding of the OP Stack derivation spec: the first byte of each field element holds
+// the top bits of the bytes at offsets 27 (0xc1), 59 (0xf2) and 91 (0x83) of the batcher data.
+var opStackVector = struct {
+	data, blob string
+}{
+	data: "0x00" + // derivation version
+		"0123456789abcdef0123456789abcdef" + "0000" + "00000063" + // channel ID, frame number, frame length
+		"00010203c105060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20212223f225262728292a2b2c2d2e2f30313233" +
+		"3435363738393a3b3c3d3e3f404142438345464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f606162" +
+		"01", // is_last
+	blob: "0x010000007b000123456789abcdef0123456789abcdef00000000006300010203" +
+		"3205060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20212223" +
+		"0325262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f40414243" +
+		"2f45464748494a4b4c4d4e4f505152535455565758595a5b5c5d5e5f60616201",
+}
+
+func TestOPStackVector(t *testing.T) {
+	data := hexutil.MustDecode(opStackVector.data)
+	blob := make([]byte, params.BlobTxFieldElementsPerBlob*32)
+	copy(blob, hexutil.MustDecode(opStackVector.blob))
+
+	enc, err := encodeOPStackBlob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc[:], blob) {
+		t.Fatalf("unexpected encoding %x", enc[:128])
+	}
+	dec, err := decodeOPStackBlob(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Fatalf("unexpected data %x", dec)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	if err := (opStackCodec{}).WriteBatch(100, 2, dec); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := os.ReadFile("100-2-0123456789abcdef0123456789abcdef-0.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame, data[23:len(data)-1]) {
+		t.Fatalf("unexpected frame data %x", frame)
+	}
+}
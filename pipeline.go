@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// txPipeline sends the transactions of an upload with sequential nonces assigned locally. Up to maxInFlight
+// transactions wait for their receipt at the same time, so several of them can be included in the same block.
+type txPipeline struct {
+	maxInFlight int
+	nonce       uint64 // nonce of the next transaction
+
+	// pendingNonce returns the pending nonce of the account, used when a nonce was taken by another transaction
+	pendingNonce func() (uint64, error)
+	// send signs and sends the transaction of blobs with nonce
+	send func(nonce uint64, blobs FullBlobStruct) (*types.Transaction, error)
+	// wait returns the receipt of a transaction once it is included
+	wait func(tx *types.Transaction) (*types.Receipt, error)
+}
+
+// pendingNoncesError is returned by run when some of the transactions it sent were not confirmed. Until they are
+// included or replaced, they hold back every later transaction of the account.
+type pendingNoncesError struct {
+	err    error
+	nonces []uint64
+}
+
+func (e *pendingNoncesError) Error() string {
+	return fmt.Sprintf("%v, transactions with nonces %v are still pending", e.err, e.nonces)
+}
+
+func (e *pendingNoncesError) Unwrap() error { return e.err }
+
+// run sends a transaction for each element of blobChannel and returns the receipts, in the order of the
+// transactions. It stops sending at the first error, so no new transaction is queued behind a nonce that may
+// never be included, and reports the nonces of the sent transactions without receipt.
+func (p *txPipeline) run(blobChannel <-chan FullBlobStruct) ([]*types.Receipt, error) {
+	maxInFlight := p.maxInFlight
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	var (
+		inFlight = make(chan struct{}, maxInFlight) // sent transactions without receipt
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		receipts []*types.Receipt
+		nonces   []uint64 // nonce of each transaction of receipts
+		waitErr  error
+		sendErr  error
+	)
+
+	for blobs := range blobChannel {
+		inFlight <- struct{}{}
+		mu.Lock()
+		failed := waitErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		tx, err := p.send(p.nonce, blobs)
+		if err != nil && isNonceTooLow(err) {
+			// Another transaction of the account took the nonce, continue after it
+			var nonce uint64
+			if nonce, err = p.pendingNonce(); err == nil {
+				log.Printf("Nonce %d is used, resending with nonce %d", p.nonce, nonce)
+				p.nonce = nonce
+				tx, err = p.send(p.nonce, blobs)
+			}
+		}
+		if err != nil {
+			sendErr = fmt.Errorf("failed to send transaction with nonce %d: %w", p.nonce, err)
+			break
+		}
+		p.nonce++
+
+		mu.Lock()
+		index := len(receipts)
+		receipts = append(receipts, nil)
+		nonces = append(nonces, tx.Nonce())
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(tx *types.Transaction) {
+			defer wg.Done()
+			receipt, err := p.wait(tx)
+			<-inFlight
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if waitErr == nil {
+					waitErr = fmt.Errorf("error waiting for transaction %v: %w", tx.Hash(), err)
+				}
+				return
+			}
+			receipts[index] = receipt
+		}(tx)
+	}
+	wg.Wait()
+
+	err := sendErr
+	if err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		return receipts, nil
+	}
+	var pending []uint64
+	for i, receipt := range receipts {
+		if receipt == nil {
+			pending = append(pending, nonces[i])
+		}
+	}
+	if len(pending) > 0 {
+		return nil, &pendingNoncesError{err: err, nonces: pending}
+	}
+	return nil, err
+}
+
+// isNonceTooLow tells if a node rejected a transaction because its nonce has been used
+func isNonceTooLow(err error) bool {
+	return strings.Contains(err.Error(), errNonceTooLowMessage)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func feedBlobs(n int) <-chan FullBlobStruct {
+	blobChannel := make(chan FullBlobStruct)
+	go func() {
+		defer close(blobChannel)
+		for i := 0; i < n; i++ {
+			blobChannel <- FullBlobStruct{}
+		}
+	}()
+	return blobChannel
+}
+
+func TestTxPipeline(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		sent    []uint64
+		active  int
+		maxSeen int
+	)
+	pipeline := &txPipeline{
+		maxInFlight: 2,
+		nonce:       5,
+		pendingNonce: func() (uint64, error) {
+			return 10, nil
+		},
+		send: func(nonce uint64, blobs FullBlobStruct) (*types.Transaction, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if nonce == 7 {
+				// Nonce 7 to 9 were used by another transaction
+				return nil, fmt.Errorf("%s: next nonce 10, tx nonce 7", errNonceTooLowMessage)
+			}
+			sent = append(sent, nonce)
+			return types.NewTx(&types.LegacyTx{Nonce: nonce}), nil
+		},
+		wait: func(tx *types.Transaction) (*types.Receipt, error) {
+			mu.Lock()
+			active++
+			if active > maxSeen {
+				maxSeen = active
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			return &types.Receipt{BlockNumber: new(big.Int).SetUint64(tx.Nonce())}, nil
+		},
+	}
+
+	receipts, err := pipeline.run(feedBlobs(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sent) != "[5 6 10 11 12]" {
+		t.Fatalf("unexpected nonces %v", sent)
+	}
+	for i, receipt := range receipts {
+		if receipt.BlockNumber.Uint64() != sent[i] {
+			t.Fatalf("receipt %d is out of order", i)
+		}
+	}
+	if maxSeen != 2 {
+		t.Fatalf("expected 2 transactions in flight, got %d", maxSeen)
+	}
+
+	// Nothing is sent after a failed transaction
+	sent = nil
+	pipeline.nonce = 0
+	pipeline.send = func(nonce uint64, blobs FullBlobStruct) (*types.Transaction, error) {
+		sent = append(sent, nonce)
+		if nonce == 1 {
+			return nil, errors.New("insufficient funds")
+		}
+		return types.NewTx(&types.LegacyTx{Nonce: nonce}), nil
+	}
+	if _, err := pipeline.run(feedBlobs(5)); err == nil {
+		t.Fatal("expected error")
+	}
+	if fmt.Sprint(sent) != "[0 1]" {
+		t.Fatalf("unexpected nonces %v", sent)
+	}
+}
+
+// Transactions queued behind one that is never included are reported with it
+func TestTxPipelinePendingNonces(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []uint64
+	)
+	pipeline := &txPipeline{
+		maxInFlight: 3,
+		send: func(nonce uint64, blobs FullBlobStruct) (*types.Transaction, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, nonce)
+			return types.NewTx(&types.LegacyTx{Nonce: nonce}), nil
+		},
+		wait: func(tx *types.Transaction) (*types.Receipt, error) {
+			if tx.Nonce() == 0 {
+				return &types.Receipt{}, nil
+			}
+			// Nonce 1 is never included, and the next ones are stuck behind it
+			time.Sleep(20 * time.Millisecond)
+			return nil, errors.New("timeout")
+		},
+	}
+
+	_, err := pipeline.run(feedBlobs(10))
+	var pending *pendingNoncesError
+	if !errors.As(err, &pending) {
+		t.Fatalf("expected pending nonces, got %v", err)
+	}
+	if len(sent) < 2 || len(sent) == 10 {
+		t.Fatalf("unexpected nonces sent %v", sent)
+	}
+	if fmt.Sprint(pending.nonces) != fmt.Sprint(sent[1:]) {
+		t.Fatalf("expected pending nonces %v, got %v", sent[1:], pending.nonces)
+	}
+}
@@ -1,5 +1,9 @@
+# The hex key is read from the environment so it does not show up in ps or in this script, e.g. set it with
+# read -rs BLOBTOSS_PRIVATE_KEY && export BLOBTOSS_PRIVATE_KEY
+: "${BLOBTOSS_PRIVATE_KEY:?set BLOBTOSS_PRIVATE_KEY to the key of the sending account}"
+
 ./blobtoss-cli tx \
-  --private-key 39725efee3fb28614de3bacaffe4cc4bd8c436257e2c8bb887c4b5c4be45e76d\
+  --private-key-env BLOBTOSS_PRIVATE_KEY \
   --chain-id 6480000002\
   --max-fee-per-blob-gas 70000000000 \
   --rpc-url http://127.0.0.1:61014 \
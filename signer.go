@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/urfave/cli"
+)
+
+// txSigner signs the transactions of an account
+type txSigner interface {
+	Address() common.Address
+	// SignTx returns tx signed for chainId. The sidecar of a blob transaction is kept.
+	SignTx(ctx context.Context, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error)
+}
+
+// readSigner returns the remote signer of --signer-url, or signs with the key of the other key flags
+func readSigner(ctx context.Context, cliCtx *cli.Context) (txSigner, error) {
+	url := cliCtx.String(TxSignerURLFlag.Name)
+	if url == "" {
+		key, err := readPrivateKey(cliCtx)
+		if err != nil {
+			return nil, err
+		}
+		return newKeySigner(key), nil
+	}
+
+	for _, flag := range []cli.StringFlag{TxPrivateKeyFlag, TxPrivateKeyEnvFlag, TxKeystoreFlag, TxMnemonicFileFlag} {
+		if cliCtx.String(flag.Name) != "" {
+			return nil, fmt.Errorf("--%s cannot be used with --%s", flag.Name, TxSignerURLFlag.Name)
+		}
+	}
+	if !common.IsHexAddress(cliCtx.String(TxSignerAddressFlag.Name)) {
+		return nil, fmt.Errorf("--%s needs the address of the account in --%s", TxSignerURLFlag.Name, TxSignerAddressFlag.Name)
+	}
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to signer %s: %w", url, err)
+	}
+	return newRemoteSigner(client, common.HexToAddress(cliCtx.String(TxSignerAddressFlag.Name)), cliCtx.String(TxSignerMethodFlag.Name)), nil
+}
+
+// keySigner signs with a private key held in memory
+type keySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func newKeySigner(key *ecdsa.PrivateKey) *keySigner {
+	return &keySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (s *keySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *keySigner) SignTx(ctx context.Context, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.NewCancunSigner(chainId), s.key)
+}
+
+// remoteSigner sends transactions to a signing service over JSON-RPC: eth_signTransaction (Web3Signer, geth) or
+// account_signTransaction (Clef). Blobs are not sent, the signature only covers their versioned hashes.
+type remoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	method  string
+}
+
+func newRemoteSigner(client *rpc.Client, address common.Address, method string) *remoteSigner {
+	return &remoteSigner{client: client, address: address, method: method}
+}
+
+// signTxArgs is the transaction sent to the signer, in the format of eth_signTransaction
+type signTxArgs struct {
+	From                 common.Address    `json:"from"`
+	To                   *common.Address   `json:"to,omitempty"`
+	Gas                  hexutil.Uint64    `json:"gas"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Nonce                hexutil.Uint64    `json:"nonce"`
+	Input                hexutil.Bytes     `json:"input"`
+	AccessList           *types.AccessList `json:"accessList,omitempty"`
+	ChainID              *hexutil.Big      `json:"chainId"`
+	Type                 hexutil.Uint64    `json:"type"`
+	MaxFeePerBlobGas     *hexutil.Big      `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes  []common.Hash     `json:"blobVersionedHashes,omitempty"`
+}
+
+// signTxResult is the answer of the signer: {raw, tx} for geth and Clef, or the raw transaction as a bare hex
+// string for Web3Signer
+type signTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func (r *signTxResult) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Raw); err == nil {
+		return nil
+	}
+	var result struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	r.Raw = result.Raw
+	return nil
+}
+
+func (s *remoteSigner) Address() common.Address {
+	return s.address
+}
+
+func (s *remoteSigner) SignTx(ctx context.Context, tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	accessList := tx.AccessList()
+	args := signTxArgs{
+		From:                 s.address,
+		To:                   tx.To(),
+		Gas:                  hexutil.Uint64(tx.Gas()),
+		MaxFeePerGas:         (*hexutil.Big)(tx.GasFeeCap()),
+		MaxPriorityFeePerGas: (*hexutil.Big)(tx.GasTipCap()),
+		Value:                (*hexutil.Big)(tx.Value()),
+		Nonce:                hexutil.Uint64(tx.Nonce()),
+		Input:                tx.Data(),
+		AccessList:           &accessList,
+		ChainID:              (*hexutil.Big)(chainId),
+		Type:                 hexutil.Uint64(tx.Type()),
+		BlobVersionedHashes:  tx.BlobHashes(),
+	}
+	if tx.Type() == types.BlobTxType {
+		args.MaxFeePerBlobGas = (*hexutil.Big)(tx.BlobGasFeeCap())
+	}
+
+	var result signTxResult
+	if err := s.client.CallContext(ctx, &result, s.method, args); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(result.Raw); err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid transaction: %w", err)
+	}
+
+	// The signature is checked to be the one of tx by the account, then set on tx to keep its sidecar
+	signer := types.NewCancunSigner(chainId)
+	if signed.Type() != tx.Type() || signer.Hash(signed) != signer.Hash(tx) {
+		return nil, errors.New("remote signer signed another transaction")
+	}
+	v, r, sv := signed.RawSignatureValues()
+	sig := make([]byte, crypto.SignatureLength)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:64])
+	sig[64] = byte(v.Uint64())
+	withSig, err := tx.WithSignature(signer, sig)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid signature: %w", err)
+	}
+	sender, err := types.Sender(signer, withSig)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer returned an invalid signature: %w", err)
+	}
+	if sender != s.address {
+		return nil, fmt.Errorf("remote signer signed with %v instead of %v", sender, s.address)
+	}
+	return withSig, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+)
+
+// testSignerAPI is an in-process stand-in for a signing service answering eth_signTransaction with key, whatever
+// the from address. tamper changes the transaction before it is signed.
+type testSignerAPI struct {
+	key    *ecdsa.PrivateKey
+	tamper func(*types.BlobTx)
+}
+
+func (api *testSignerAPI) SignTransaction(args signTxArgs) (*signTxResult, error) {
+	raw, err := api.sign(args)
+	if err != nil {
+		return nil, err
+	}
+	return &signTxResult{Raw: raw}, nil
+}
+
+func (api *testSignerAPI) sign(args signTxArgs) (hexutil.Bytes, error) {
+	if args.Type != types.BlobTxType || args.To == nil || args.MaxFeePerBlobGas == nil {
+		return nil, errors.New("only blob transactions are supported")
+	}
+	blobTx := &types.BlobTx{
+		ChainID:    uint256.MustFromBig(args.ChainID.ToInt()),
+		Nonce:      uint64(args.Nonce),
+		GasTipCap:  uint256.MustFromBig(args.MaxPriorityFeePerGas.ToInt()),
+		GasFeeCap:  uint256.MustFromBig(args.MaxFeePerGas.ToInt()),
+		Gas:        uint64(args.Gas),
+		To:         *args.To,
+		Value:      uint256.MustFromBig(args.Value.ToInt()),
+		Data:       args.Input,
+		BlobFeeCap: uint256.MustFromBig(args.MaxFeePerBlobGas.ToInt()),
+		BlobHashes: args.BlobVersionedHashes,
+	}
+	if args.AccessList != nil {
+		blobTx.AccessList = *args.AccessList
+	}
+	if api.tamper != nil {
+		api.tamper(blobTx)
+	}
+	tx, err := types.SignTx(types.NewTx(blobTx), types.NewCancunSigner(args.ChainID.ToInt()), api.key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.MarshalBinary()
+}
+
+// web3SignerAPI answers eth_signTransaction like Web3Signer, with the raw transaction as a bare hex string
+type web3SignerAPI struct {
+	testSignerAPI
+}
+
+func (api *web3SignerAPI) SignTransaction(args signTxArgs) (hexutil.Bytes, error) {
+	return api.sign(args)
+}
+
+func newTestRemoteSigner(t *testing.T, api interface{}, address common.Address) *remoteSigner {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return newRemoteSigner(rpc.DialInProc(server), address, "eth_signTransaction")
+}
+
+func testBlobTx(t *testing.T) *types.Transaction {
+	var blob kzg4844.Blob
+	commitment, err := kzg4844.BlobToCommitment(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := kzg4844.ComputeBlobProof(blob, commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar := &types.BlobTxSidecar{Blobs: []kzg4844.Blob{blob}, Commitments: []kzg4844.Commitment{commitment}, Proofs: []kzg4844.Proof{proof}}
+	return types.NewTx(&types.BlobTx{
+		ChainID:    uint256.NewInt(7011893061),
+		Nonce:      3,
+		GasTipCap:  uint256.NewInt(1e9),
+		GasFeeCap:  uint256.NewInt(5e9),
+		Gas:        21000,
+		To:         common.HexToAddress("0x1"),
+		Value:      uint256.NewInt(0),
+		Data:       []byte("calldata"),
+		BlobFeeCap: uint256.NewInt(3e9),
+		BlobHashes: sidecar.BlobHashes(),
+		Sidecar:    sidecar,
+	})
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainId := big.NewInt(7011893061)
+	tx := testBlobTx(t)
+
+	local, err := newKeySigner(key).SignTx(context.Background(), tx, chainId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apis := map[string]interface{}{
+		"geth":       &testSignerAPI{key: key},
+		"web3signer": &web3SignerAPI{testSignerAPI{key: key}},
+	}
+	for name, api := range apis {
+		signed, err := newTestRemoteSigner(t, api, address).SignTx(context.Background(), tx, chainId)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if sender, err := types.Sender(types.NewCancunSigner(chainId), signed); err != nil || sender != address {
+			t.Fatalf("%s: expected sender %v, got %v (%v)", name, address, sender, err)
+		}
+		if signed.BlobTxSidecar() == nil {
+			t.Fatalf("%s: the sidecar was dropped", name)
+		}
+		// The remote signature is the one of the local key
+		if signed.Hash() != local.Hash() {
+			t.Fatalf("%s: expected %v, got %v", name, local.Hash(), signed.Hash())
+		}
+	}
+}
+
+func TestRemoteSignerChecks(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainId := big.NewInt(7011893061)
+	tx := testBlobTx(t)
+
+	tests := map[string]*testSignerAPI{
+		"other transaction": {key: key, tamper: func(blobTx *types.BlobTx) { blobTx.Nonce++ }},
+		"other fees":        {key: key, tamper: func(blobTx *types.BlobTx) { blobTx.BlobFeeCap = uint256.NewInt(1) }},
+	}
+	for name, api := range tests {
+		if _, err := newTestRemoteSigner(t, api, address).SignTx(context.Background(), tx, chainId); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// A signer signing with another account than the configured one
+	if _, err := newTestRemoteSigner(t, &testSignerAPI{key: other}, address).SignTx(context.Background(), tx, chainId); err == nil {
+		t.Error("expected error for a signature of another account")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+)
+
+const (
+	g1PointSize = 48
+	g2PointSize = 96
+)
+
+// trustedSetupFile is the JSON trusted setup format of go-ethereum, go-kzg and the consensus specs
+type trustedSetupFile struct {
+	G1Lagrange []string `json:"g1_lagrange"`
+	G2Monomial []string `json:"g2_monomial"`
+}
+
+// loadTrustedSetup reads a KZG trusted setup in the JSON format or in the text format of c-kzg
+// (trusted_setup.txt) and checks that its points are valid
+func loadTrustedSetup(filename string) (*gokzg4844.JSONTrustedSetup, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trusted setup: %v", err)
+	}
+	var setup *trustedSetupFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		setup, err = parseJSONTrustedSetup(trimmed)
+	} else {
+		setup, err = parseTextTrustedSetup(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted setup %s: %v", filename, err)
+	}
+
+	result, err := setup.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted setup %s: %v", filename, err)
+	}
+	if err := gokzg4844.CheckTrustedSetupIsWellFormed(result); err != nil {
+		return nil, fmt.Errorf("invalid trusted setup %s: %v", filename, err)
+	}
+	return result, nil
+}
+
+func parseJSONTrustedSetup(data []byte) (*trustedSetupFile, error) {
+	var setup trustedSetupFile
+	if err := json.Unmarshal(data, &setup); err != nil {
+		return nil, err
+	}
+	return &setup, nil
+}
+
+// parseTextTrustedSetup parses the c-kzg format: the number of G1 and G2 points, then the G1 points in Lagrange
+// form and the G2 points in monomial form, all in hex without 0x. Newer files add the G1 points in monomial form
+// at the end, which are not needed.
+func parseTextTrustedSetup(data string) (*trustedSetupFile, error) {
+	fields := strings.Fields(data)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("missing number of points")
+	}
+	numG1, err := strconv.Atoi(fields[0])
+	if err != nil || numG1 < 0 {
+		return nil, fmt.Errorf("invalid number of G1 points %q", fields[0])
+	}
+	numG2, err := strconv.Atoi(fields[1])
+	if err != nil || numG2 < 0 {
+		return nil, fmt.Errorf("invalid number of G2 points %q", fields[1])
+	}
+	points := fields[2:]
+	if len(points) != numG1+numG2 && len(points) != 2*numG1+numG2 {
+		return nil, fmt.Errorf("expected %d G1 and %d G2 points, found %d points", numG1, numG2, len(points))
+	}
+	return &trustedSetupFile{
+		G1Lagrange: points[:numG1],
+		G2Monomial: points[numG1 : numG1+numG2],
+	}, nil
+}
+
+// check validates the size and encoding of the points and converts them to the format of go-kzg
+func (s *trustedSetupFile) check() (*gokzg4844.JSONTrustedSetup, error) {
+	if len(s.G1Lagrange) != gokzg4844.ScalarsPerBlob {
+		return nil, fmt.Errorf("expected %d G1 points, found %d", gokzg4844.ScalarsPerBlob, len(s.G1Lagrange))
+	}
+	if len(s.G2Monomial) < 2 {
+		return nil, fmt.Errorf("expected at least 2 G2 points, found %d", len(s.G2Monomial))
+	}
+
+	var setup gokzg4844.JSONTrustedSetup
+	for i, point := range s.G1Lagrange {
+		hexPoint, err := checkSetupPoint(point, g1PointSize)
+		if err != nil {
+			return nil, fmt.Errorf("G1 point %d: %v", i, err)
+		}
+		setup.SetupG1Lagrange[i] = hexPoint
+	}
+	for i, point := range s.G2Monomial {
+		hexPoint, err := checkSetupPoint(point, g2PointSize)
+		if err != nil {
+			return nil, fmt.Errorf("G2 point %d: %v", i, err)
+		}
+		setup.SetupG2 = append(setup.SetupG2, hexPoint)
+	}
+	return &setup, nil
+}
+
+// checkSetupPoint checks that point is the hex encoding of size bytes and returns it with the 0x prefix
+func checkSetupPoint(point string, size int) (string, error) {
+	point = strings.TrimPrefix(point, "0x")
+	b, err := hex.DecodeString(point)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex: %v", err)
+	}
+	if len(b) != size {
+		return "", fmt.Errorf("expected %d bytes, found %d", size, len(b))
+	}
+	return "0x" + point, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bls12381 "github.com/consensys/gnark-crypto/ecc/bls12-381"
+	"github.com/consensys/gnark-crypto/ecc/bls12-381/fr"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+// makeTestTrustedSetup returns an insecure trusted setup built from a known secret: the G1 points in Lagrange
+// form over the 4096th roots of unity used by go-kzg, and G2 and tau*G2
+func makeTestTrustedSetup(tau int64) *trustedSetupFile {
+	const n = 4096
+
+	var root fr.Element
+	root.SetString("10238227357739495823651030575849232062558860180284477541189508159991286009131")
+	root.Exp(root, big.NewInt(int64(uint64(1)<<32/n)))
+
+	// L_i(tau) = w^i (tau^n - 1) / (n (tau - w^i))
+	var t, tauN, num, nInv fr.Element
+	t.SetInt64(tau)
+	tauN.Exp(t, big.NewInt(n))
+	num.SetOne()
+	num.Sub(&tauN, &num)
+	nInv.SetUint64(n)
+	nInv.Inverse(&nInv)
+	num.Mul(&num, &nInv)
+
+	roots := make([]fr.Element, n)
+	denominators := make([]fr.Element, n)
+	roots[0].SetOne()
+	for i := range roots {
+		if i > 0 {
+			roots[i].Mul(&roots[i-1], &root)
+		}
+		denominators[i].Sub(&t, &roots[i])
+	}
+	inverses := fr.BatchInvert(denominators)
+	scalars := make([]fr.Element, n)
+	for i := range scalars {
+		scalars[i].Mul(&roots[i], &num)
+		scalars[i].Mul(&scalars[i], &inverses[i])
+	}
+
+	_, _, g1, g2 := bls12381.Generators()
+	var setup trustedSetupFile
+	for _, point := range bls12381.BatchScalarMultiplicationG1(&g1, scalars) {
+		b := point.Bytes()
+		setup.G1Lagrange = append(setup.G1Lagrange, hex.EncodeToString(b[:]))
+	}
+	var tauG2 bls12381.G2Affine
+	tauG2.ScalarMultiplication(&g2, big.NewInt(tau))
+	for _, point := range []bls12381.G2Affine{g2, tauG2} {
+		b := point.Bytes()
+		setup.G2Monomial = append(setup.G2Monomial, hex.EncodeToString(b[:]))
+	}
+	return &setup
+}
+
+func writeTextTrustedSetup(t *testing.T, filename string, setup *trustedSetupFile) {
+	lines := []string{fmt.Sprint(len(setup.G1Lagrange)), fmt.Sprint(len(setup.G2Monomial))}
+	lines = append(lines, setup.G1Lagrange...)
+	lines = append(lines, setup.G2Monomial...)
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeJSONTrustedSetup(t *testing.T, filename string, setup *trustedSetupFile) {
+	prefixed := &trustedSetupFile{}
+	for _, point := range setup.G1Lagrange {
+		prefixed.G1Lagrange = append(prefixed.G1Lagrange, "0x"+point)
+	}
+	for _, point := range setup.G2Monomial {
+		prefixed.G2Monomial = append(prefixed.G2Monomial, "0x"+point)
+	}
+	data, err := json.Marshal(prefixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadTrustedSetup(t *testing.T) {
+	dir := t.TempDir()
+	setup := makeTestTrustedSetup(1337)
+
+	textFile := filepath.Join(dir, "trusted_setup.txt")
+	writeTextTrustedSetup(t, textFile, setup)
+	fromText, err := loadTrustedSetup(textFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "trusted_setup.json")
+	writeJSONTrustedSetup(t, jsonFile, setup)
+	fromJSON, err := loadTrustedSetup(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromText, fromJSON) {
+		t.Fatal("text and JSON setups differ")
+	}
+
+	// Proofs made with the custom setup verify with it, but not with the mainnet one
+	defer func(backend kzgBackend) { kzg = backend }(kzg)
+	kzg = newGoKZG(fromText)
+	sidecar, _, err := EncodeBlobs([]byte("devnet"), EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kzg.VerifyBlobProof(sidecar.Blobs[0], sidecar.Commitments[0], sidecar.Proofs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := kzg4844.VerifyBlobProof(sidecar.Blobs[0], sidecar.Commitments[0], sidecar.Proofs[0]); err == nil {
+		t.Fatal("expected the mainnet setup to reject the proof")
+	}
+
+	malformed := map[string]func(*trustedSetupFile){
+		"missing G1 point": func(s *trustedSetupFile) { s.G1Lagrange = s.G1Lagrange[1:] },
+		"missing G2 point": func(s *trustedSetupFile) { s.G2Monomial = s.G2Monomial[:1] },
+		"bad hex":          func(s *trustedSetupFile) { s.G1Lagrange[7] = "zz" + s.G1Lagrange[7][2:] },
+		"short point":      func(s *trustedSetupFile) { s.G2Monomial[1] = s.G2Monomial[1][2:] },
+		"invalid point":    func(s *trustedSetupFile) { s.G1Lagrange[0] = strings.Repeat("ff", g1PointSize) },
+	}
+	for name, corrupt := range malformed {
+		bad := &trustedSetupFile{
+			G1Lagrange: append([]string(nil), setup.G1Lagrange...),
+			G2Monomial: append([]string(nil), setup.G2Monomial...),
+		}
+		corrupt(bad)
+		writeTextTrustedSetup(t, textFile, bad)
+		if _, err := loadTrustedSetup(textFile); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if err := os.WriteFile(textFile, []byte("4096 65\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTrustedSetup(textFile); err == nil {
+		t.Error("truncated file: expected error")
+	}
+}
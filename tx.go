@@ -1,19 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
-	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/holiman/uint256"
 	"github.com/urfave/cli"
@@ -22,16 +25,25 @@ import (
 type BlobUploadParams struct {
 	Host             string
 	To               common.Address
-	PrivateKey       string
+	Signer           txSigner
 	File             string
 	Value            string
 	GasLimit         uint64
 	GasPrice         string
 	PriorityGasPrice string
 	MaxFeePerBlobGas string
+	BlobFeeHeadroom  float64 // used when MaxFeePerBlobGas is auto
 	ChainID          string
 	Calldata         string
 	BlobsPerTx       int
+	MaxInFlight      int            // transactions sent without waiting for their receipt, 1 sends them one at a time
+	BumpAfter        uint64         // blocks without inclusion after which a transaction is replaced, 0 never replaces it
+	BumpPercent      uint64         // fee increase of replacement transactions
+	MaxBumps         int            // replacements of a transaction
+	Confirmations    uint64         // blocks, including its own, that must hold a transaction before it counts as included
+	ReceiptTimeout   time.Duration  // maximum wait for the receipt of each transaction, 0 waits forever
+	Journal          *uploadJournal // progress of the upload, a new one kept in memory if nil
+	EncodeOptions    EncodeOptions
 }
 
 func MultipartUpload(params BlobUploadParams) (uint64, error) {
@@ -40,10 +52,17 @@ func MultipartUpload(params BlobUploadParams) (uint64, error) {
 		return 0, fmt.Errorf("invalid value param: %v", err)
 	}
 
-	data, err := os.ReadFile(params.File)
+	journal := params.Journal
+	if journal == nil {
+		journal = newUploadJournal("", &params)
+	}
+	params.EncodeOptions.Seed = journal.Seed
+
+	file, data, err := openUpload(params.File, &params.EncodeOptions)
 	if err != nil {
-		return 0, fmt.Errorf("error reading blob file: %v", err)
+		return 0, err
 	}
+	defer file.Close()
 
 	chainId, _ := new(big.Int).SetString(params.ChainID, 0)
 
@@ -53,10 +72,7 @@ func MultipartUpload(params BlobUploadParams) (uint64, error) {
 		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
 
-	key, err := crypto.HexToECDSA(params.PrivateKey)
-	if err != nil {
-		return 0, fmt.Errorf("%w: invalid private key", err)
-	}
+	signer := params.Signer
 
 	var gasPrice256 *uint256.Int
 	if params.GasPrice == "" {
@@ -84,47 +100,110 @@ func MultipartUpload(params BlobUploadParams) (uint64, error) {
 		}
 	}
 
-	maxFeePerBlobGas256, err := DecodeUint256String(params.MaxFeePerBlobGas)
-	if err != nil {
-		return 0, fmt.Errorf("%w: invalid max_fee_per_blob_gas", err)
-	}
-
 	calldataBytes, err := common.ParseHexOrString(params.Calldata)
 	if err != nil {
 		log.Fatalf("failed to parse calldata: %v", err)
 	}
 
-	var totalBlobGasUsed uint64
+	address := signer.Address()
+	log.Println("Address:", address.String())
 
-	blobChannel := make(chan FullBlobStruct)
-	go EncodeMultipartBlob(blobChannel, data, params.BlobsPerTx)
+	// Transactions of a resumed upload that were not seen confirmed may have been included since
+	pending := journal.pending()
+	for _, b := range pending {
+		for _, hash := range b.Txs {
+			if receipt, err := client.TransactionReceipt(ctx, hash); err == nil {
+				if err := journal.confirmed(receipt); err != nil {
+					log.Printf("Error writing journal: %v", err)
+				}
+				break
+			}
+		}
+	}
 
-	// First slot in which the transaction to upload blobs begins
-	var initialSlot uint64
+	// Nonces are assigned locally so transactions can be sent before the previous ones are included
+	nonce, err := client.PendingNonceAt(ctx, address)
+	if err != nil {
+		return 0, fmt.Errorf("error getting nonce: %w", err)
+	}
+	if pending = journal.pending(); len(pending) > 0 {
+		// The transactions of the previous run may still be in the mempool. Sending their blobs again from the
+		// first unused nonce replaces them instead of posting the blobs twice.
+		if nonce, err = client.NonceAt(ctx, address, nil); err != nil {
+			return 0, fmt.Errorf("error getting nonce: %w", err)
+		}
+		log.Printf("%d transactions of the journal are not confirmed, sending them again from nonce %d", len(pending), nonce)
+	}
 
-	for {
-		select {
-		case blobStruct, ok := <-blobChannel:
-			if !ok {
-				fmt.Printf("Operation costed %d BlobGas\n", totalBlobGasUsed)
-				return initialSlot, nil
-			}
+	if priorityGasPrice256.Cmp(gasPrice256) > 0 {
+		log.Println("Adjusting GasTipCap to be equal to GasFeeCap because GasTipCap was higher")
+		priorityGasPrice256 = gasPrice256
+	}
 
-			address := crypto.PubkeyToAddress(key.PublicKey)
-			log.Println("Address:", address.String())
+	// The encoder and the journal filter stop when the upload returns, even if the pipeline did not read all the blobs
+	encodeCtx, stopEncoding := context.WithCancel(ctx)
+	defer stopEncoding()
+	encoded := make(chan FullBlobStruct)
+	encodeErr := make(chan error, 1)
+	go func() {
+		encodeErr <- EncodeMultipartBlob(encodeCtx, encoded, data, params.BlobsPerTx, params.EncodeOptions)
+	}()
 
-			pendingNonce, err := client.PendingNonceAt(ctx, address)
-			if err != nil {
-				log.Fatalf("Error getting nonce in tx: %v", err)
+	// Transactions confirmed in a previous run are skipped, once their blobs are checked to be the same
+	blobChannel := make(chan FullBlobStruct)
+	var journalErr error
+	go func() {
+		defer close(blobChannel)
+		for blobStruct := range encoded {
+			if journalErr != nil {
+				continue
 			}
-			nonce := uint64(pendingNonce)
-
-			if priorityGasPrice256.Cmp(gasPrice256) > 0 {
-				log.Println("Adjusting GasTipCap to be equal to GasFeeCap because GasTipCap was higher")
-				priorityGasPrice256 = gasPrice256
+			b, ok := journal.batch(blobStruct.Index)
+			if ok && !sameHashes(b.VersionedHashes, blobStruct.VersionedHashes) {
+				journalErr = fmt.Errorf("transaction %d of the journal has other blobs, the file or the options changed", blobStruct.Index)
+				continue
 			}
-
-			tx := types.NewTx(&types.BlobTx{
+			if ok && b.Status == journalConfirmed {
+				log.Printf("Skipping transaction %d, confirmed in block %d", blobStruct.Index, b.Block)
+				continue
+			}
+			select {
+			case blobChannel <- blobStruct:
+			case <-encodeCtx.Done():
+				return
+			}
+		}
+	}()
+
+	waiter := &receiptWaiter{
+		client:        client,
+		timeout:       params.ReceiptTimeout,
+		confirmations: params.Confirmations,
+		bumpAfter:     params.BumpAfter,
+		maxBumps:      params.MaxBumps,
+		bump: func(tx *types.Transaction) (*types.Transaction, error) {
+			replacement, err := replaceBlobTx(ctx, client, signer, tx, params.BumpPercent, params.BlobFeeHeadroom)
+			if err == nil {
+				if err := journal.replaced(tx.Hash(), replacement); err != nil {
+					log.Printf("Error writing journal: %v", err)
+				}
+			}
+			return replacement, err
+		},
+	}
+	pipeline := &txPipeline{
+		maxInFlight: params.MaxInFlight,
+		nonce:       nonce,
+		pendingNonce: func() (uint64, error) {
+			return client.PendingNonceAt(ctx, address)
+		},
+		send: func(nonce uint64, blobStruct FullBlobStruct) (*types.Transaction, error) {
+			// With auto, the fee follows the blob base fee of every transaction
+			maxFeePerBlobGas256, err := resolveMaxFeePerBlobGas(ctx, client, params.MaxFeePerBlobGas, params.BlobFeeHeadroom)
+			if err != nil {
+				return nil, err
+			}
+			signedTx, err := sendBlobTxBumping(ctx, client, signer, chainId, &types.BlobTx{
 				ChainID:    uint256.MustFromBig(chainId),
 				Nonce:      nonce,
 				GasTipCap:  priorityGasPrice256,
@@ -136,69 +215,204 @@ func MultipartUpload(params BlobUploadParams) (uint64, error) {
 				BlobFeeCap: maxFeePerBlobGas256,
 				BlobHashes: blobStruct.VersionedHashes,
 				Sidecar:    &blobStruct.Sidecar,
-			})
-
-			log.Println("Tx params:")
-			log.Println("ChainID:", chainId)
-			log.Println("Nonce:", nonce)
-			log.Println("GasTipCap:", priorityGasPrice256.String())
-			log.Println("GasFeeCap:", gasPrice256.String())
-			log.Println("Gas:", params.GasLimit)
-			log.Println("To:", params.To.String())
-			log.Println("Value:", value256.String())
-			log.Println("Data:", calldataBytes)
-			log.Println("BlobFeeCap:", maxFeePerBlobGas256.String())
-
-			signedTx, err := types.SignTx(tx, types.NewCancunSigner(chainId), key)
+			}, params.BumpPercent)
 			if err != nil {
-				log.Fatalf("failed to sign tx: %v", err)
+				return nil, err
 			}
-
-			rlpData, err := signedTx.MarshalBinary()
+			log.Printf("successfully sent transaction with %d blobs. Check https://blobscan.com/tx/%v", len(blobStruct.Sidecar.Blobs), signedTx.Hash())
+			if err := journal.sent(blobStruct, signedTx); err != nil {
+				log.Printf("Error writing journal: %v", err)
+			}
+			return signedTx, nil
+		},
+		wait: func(tx *types.Transaction) (*types.Receipt, error) {
+			receipt, err := waiter.wait(ctx, tx)
 			if err != nil {
-				log.Fatalf("failed to marshal tx: %v", err)
+				return nil, err
 			}
+			if err := journal.confirmed(receipt); err != nil {
+				log.Printf("Error writing journal: %v", err)
+			}
+			log.Printf("Transaction confirmed. nonce=%d, bloGasUsed=%d, blobGasPrice=%d. Check https://blobscan.com/block/%d", tx.Nonce(), receipt.BlobGasUsed, receipt.BlobGasPrice, receipt.BlockNumber.Int64())
+			return receipt, nil
+		},
+	}
+	if _, err := pipeline.run(blobChannel); err != nil {
+		var pending *pendingNoncesError
+		if errors.As(err, &pending) && journal.path != "" && !journal.Encrypted {
+			// Resuming sends the blobs of the unconfirmed transactions again from the first unused nonce
+			return 0, fmt.Errorf("%w, run the upload again with --%s %s to replace them", err, TxResumeFlag.Name, journal.path)
+		}
+		return 0, err
+	}
+	// The encoder is done once all its transactions went through the pipeline
+	if err := <-encodeErr; err != nil {
+		return 0, fmt.Errorf("error encoding blobs, the upload is incomplete: %w", err)
+	}
+	if journalErr != nil {
+		return 0, journalErr
+	}
+	fmt.Printf("Operation costed %d BlobGas\n", journal.blobGasUsed())
 
-			err = client.Client().CallContext(context.Background(), nil, "eth_sendRawTransaction", hexutil.Encode(rlpData))
+	// First slot in which the transaction to upload blobs begins
+	var initialSlot uint64
+	if first, ok := journal.batch(0); ok && first.Status == journalConfirmed {
+		// Wait until the new block is indexed
+		time.Sleep(24 * time.Second)
+		initialSlot, err = GetSlotFromBlock(int64(first.Block))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return initialSlot, nil
+}
 
-			if err != nil {
-				log.Fatalf("failed to send transaction: %v", err)
-			} else {
-				log.Printf("successfully sent transaction with %d blobs. Check https://blobscan.com/tx/%v", len(blobStruct.Sidecar.Blobs), signedTx.Hash())
-				//for idxBlob, blob := range blobStruct.Sidecar.Blobs {
-				//	log.Printf("Blob #%d: Size=%d", idxBlob, len(blob))
-				//}
-			}
+// sameHashes tells if a and b hold the same versioned hashes
+func sameHashes(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
 
-			var receipt *types.Receipt
-
-			for {
-				receipt, err = client.TransactionReceipt(context.Background(), signedTx.Hash())
-				if err == ethereum.NotFound {
-					time.Sleep(1 * time.Second)
-				} else if err != nil {
-					if _, ok := err.(*json.UnmarshalTypeError); ok {
-						// TODO: ignore other errors for now. Some clients are treating the blobGasUsed as big.Int rather than uint64
-						break
-					}
-				} else {
-					break
-				}
-			}
+// sendBlobTx signs a blob transaction and sends it with its sidecar
+func sendBlobTx(ctx context.Context, client *ethclient.Client, signer txSigner, chainId *big.Int, blobTx *types.BlobTx) (*types.Transaction, error) {
+	log.Println("Tx params:")
+	log.Println("ChainID:", chainId)
+	log.Println("Nonce:", blobTx.Nonce)
+	log.Println("GasTipCap:", blobTx.GasTipCap.String())
+	log.Println("GasFeeCap:", blobTx.GasFeeCap.String())
+	log.Println("Gas:", blobTx.Gas)
+	log.Println("To:", blobTx.To.String())
+	log.Println("Value:", blobTx.Value.String())
+	log.Println("Data:", blobTx.Data)
+	log.Println("BlobFeeCap:", blobTx.BlobFeeCap.String())
+
+	signedTx, err := signer.SignTx(ctx, types.NewTx(blobTx), chainId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign tx: %w", err)
+	}
+	rlpData, err := signedTx.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tx: %w", err)
+	}
+	err = client.Client().CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(rlpData))
+	if err != nil && !isAlreadyKnown(err) {
+		return nil, err
+	}
+	// A node that already has the transaction got it from a previous attempt
+	return signedTx, nil
+}
 
-			log.Printf("Transaction included. nonce=%d, bloGasUsed=%d, blobGasPrice=%d. Check https://blobscan.com/block/%d", nonce, receipt.BlobGasUsed, receipt.BlobGasPrice, receipt.BlockNumber.Int64())
-			if initialSlot == 0 {
-				// Wait until the new block is indexed
-				time.Sleep(24 * time.Second)
-				initialSlot, err = GetSlotFromBlock(receipt.BlockNumber.Int64())
-				if err != nil {
-					return 0, err
-				}
-			}
-			//log.Printf("Transaction included. nonce=%d hash=%v, block=%d, bloGasUsed=%d, blobGasPrice=%d", nonce, tx.Hash(), receipt.BlockNumber.Int64(), receipt.BlobGasUsed, receipt.BlobGasPrice)
-			totalBlobGasUsed += receipt.BlobGasUsed
+// openUpload opens the file, directory or stdin to upload. It fills the filename, content type and archive index
+// of opts and returns the reader of the data to encode.
+func openUpload(name string, opts *EncodeOptions) (io.Closer, io.Reader, error) {
+	var (
+		file io.ReadCloser
+		err  error
+	)
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		// Directories are uploaded as an archive of all their files
+		entries, err := readArchiveIndex(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading directory: %v", err)
+		}
+		fmt.Printf("Uploading %d files from %s\n", len(entries), name)
+		opts.Archive = entries
+		if opts.ContentType == "" {
+			opts.ContentType = archiveContentType
+		}
+		file = newArchiveReader(name, entries)
+	} else if file, err = openBlobFile(name); err != nil {
+		return nil, nil, fmt.Errorf("error reading blob file: %v", err)
+	}
+
+	if opts.Filename == "" && name != blobFileStdin {
+		opts.Filename = filepath.Base(name)
+	}
+	var data io.Reader = file
+	if opts.ContentType == "" {
+		opts.ContentType, data, err = sniffContentType(opts.Filename, file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("error reading blob file: %v", err)
+		}
+	}
+	return file, data, nil
+}
+
+// blobFileStdin is the --blob-file value used to read the file from stdin
+const blobFileStdin = "-"
+
+func openBlobFile(name string) (*os.File, error) {
+	if name == blobFileStdin {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// sniffContentType reads the first bytes of r to detect the type of the file and returns a reader of the whole file
+func sniffContentType(filename string, r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+	contentType := detectContentType(filename, head)
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(int64(-n), io.SeekCurrent); err == nil {
+			return contentType, r, nil
+		}
+	}
+	return contentType, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+func detectContentType(filename string, data []byte) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(data)
+}
+
+// readEncodeOptions reads the flags of the encoding of multipart uploads
+func readEncodeOptions(cliCtx *cli.Context) (EncodeOptions, error) {
+	compression, err := parseCompression(cliCtx.String(TxCompressionFlag.Name))
+	if err != nil {
+		return EncodeOptions{}, err
+	}
+
+	var recipients [][]byte
+	for _, r := range cliCtx.StringSlice(TxRecipientFlag.Name) {
+		recipient, err := parseX25519Key(r)
+		if err != nil {
+			return EncodeOptions{}, fmt.Errorf("invalid recipient %s: %w", r, err)
 		}
+		recipients = append(recipients, recipient)
 	}
+
+	dataBlobs := cliCtx.Int(TxDataBlobsFlag.Name)
+	parityBlobs := cliCtx.Int(TxParityBlobsFlag.Name)
+	if parityBlobs > 0 {
+		if err := validateErasureOptions(dataBlobs, parityBlobs); err != nil {
+			return EncodeOptions{}, err
+		}
+	}
+
+	return EncodeOptions{
+		Dense:        cliCtx.Bool(TxDenseFlag.Name),
+		DataShards:   dataBlobs,
+		ParityShards: parityBlobs,
+		Compression:  compression,
+		Recipients:   recipients,
+		Concurrency:  cliCtx.Int(TxConcurrencyFlag.Name),
+	}, nil
 }
 
 // TODO: block parameter
@@ -207,7 +421,6 @@ func MultiTxApp(cliCtx *cli.Context) error {
 
 	addr := cliCtx.String(TxRPCURLFlag.Name)
 	to := common.HexToAddress(cliCtx.String(TxToFlag.Name))
-	prv := cliCtx.String(TxPrivateKeyFlag.Name)
 	file := cliCtx.String(TxBlobFileFlag.Name)
 	// nonce := cliCtx.Int64(TxNonceFlag.Name)
 	value := cliCtx.String(TxValueFlag.Name)
@@ -218,23 +431,58 @@ func MultiTxApp(cliCtx *cli.Context) error {
 	chainID := cliCtx.String(TxChainID.Name)
 	calldata := cliCtx.String(TxCalldata.Name)
 	blobsPerTx := cliCtx.Int(MultiTxBlobsPerTx.Name)
+	encodeOptions, err := readEncodeOptions(cliCtx)
+	if err != nil {
+		return err
+	}
+	if err := setupKZG(cliCtx); err != nil {
+		return err
+	}
+	signer, err := readSigner(context.Background(), cliCtx)
+	if err != nil {
+		return err
+	}
 
 	params := BlobUploadParams{
 		Host:             addr,
 		To:               to,
-		PrivateKey:       prv,
+		Signer:           signer,
 		File:             file,
 		Value:            value,
 		GasLimit:         gasLimit,
 		GasPrice:         gasPrice,
 		PriorityGasPrice: priorityGasPrice,
 		MaxFeePerBlobGas: maxFeePerBlobGas,
+		BlobFeeHeadroom:  cliCtx.Float64(TxBlobFeeHeadroomFlag.Name),
 		ChainID:          chainID,
 		Calldata:         calldata,
 		BlobsPerTx:       blobsPerTx,
+		MaxInFlight:      cliCtx.Int(MultiTxMaxInFlightFlag.Name),
+		BumpAfter:        cliCtx.Uint64(TxBumpAfterFlag.Name),
+		BumpPercent:      cliCtx.Uint64(TxBumpPercentFlag.Name),
+		MaxBumps:         cliCtx.Int(TxMaxBumpsFlag.Name),
+		Confirmations:    cliCtx.Uint64(TxConfirmationsFlag.Name),
+		ReceiptTimeout:   cliCtx.Duration(TxReceiptTimeoutFlag.Name),
+		EncodeOptions:    encodeOptions,
+	}
+
+	if resume := cliCtx.String(TxResumeFlag.Name); resume != "" {
+		if params.Journal, err = loadUploadJournal(resume); err != nil {
+			return err
+		}
+		if err := params.Journal.resume(&params); err != nil {
+			return err
+		}
+		log.Printf("Resuming the upload from %s, %d transactions are confirmed", resume, len(params.Journal.Batches)-len(params.Journal.pending()))
+	} else {
+		params.Journal = newUploadJournal(cliCtx.String(TxJournalFlag.Name), &params)
+		if params.Journal.path == "" {
+			params.Journal.path = defaultJournalPath(params.Journal.Seed)
+		}
+		log.Printf("Writing the progress of the upload to %s, resume it with --resume %s", params.Journal.path, params.Journal.path)
 	}
 
-	_, err := MultipartUpload(params)
+	_, err = MultipartUpload(params)
 	if err != nil {
 		fmt.Println(err)
 		return err
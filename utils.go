@@ -1,14 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -20,6 +22,7 @@ import (
 type FullBlobStruct struct {
 	Sidecar         types.BlobTxSidecar
 	VersionedHashes []common.Hash
+	Index           int // position of the transaction in the upload
 }
 
 type BlobscanBlockResponse struct {
@@ -54,153 +57,415 @@ func GetSlotFromBlock(block int64) (uint64, error) {
 	return responseObject.Slot, nil
 }
 
-func getTotalBlobs(data []byte) int {
-	fileSize := len(data)
-	totalBlobs := fileSize / 131072
-	remainder := fileSize % 131072
+// magicPrefix is the string we use to identify blobs that are part of a multipart file
+var magicPrefix = []byte("BlobsAreComing.")
 
-	if remainder > 0 {
-		totalBlobs += 1
+const (
+	// magicHeaderV1 stores blobIndex and totalBlobs as single bytes, so files are limited to 255 blobs.
+	// It is only kept around to read old uploads.
+	magicHeaderV1 = 1
+	// magicHeaderV2 keeps the first field element of v1 (prefix, version and seed) and moves blobIndex
+	// and totalBlobs to a second field element as 32 bits integers.
+	magicHeaderV2 = 2
+
+	magicHeaderVersionOffset = 15
+	magicHeaderSeedOffset    = 24
+	magicHeaderFlagsOffset   = 41
+)
+
+// Magic header flags (v2 only)
+const (
+	// headerFlagLength is set when the header contains the exact length of the payload of the blob
+	// and the size of the file. Without it, trailing 0s are removed from the payload when decoding.
+	headerFlagLength byte = 1 << iota
+	// headerFlagDense is set when the payload is packed with the dense codec (254 bits per field element)
+	headerFlagDense
+	// headerFlagManifest is set on the first blob of a file when it contains the manifest instead of data
+	headerFlagManifest
+	// headerFlagErasure is set when the file is erasure coded (see erasure.go). The payload of every blob is a shard.
+	headerFlagErasure
+	// headerFlagEncrypted is set when the file is encrypted (see encrypt.go). Keys are stored in the manifest.
+	headerFlagEncrypted
+)
+
+// EncodeOptions controls how data is laid out in blobs
+type EncodeOptions struct {
+	// Dense packs 254 bits per field element instead of 31 bytes
+	Dense bool
+	// DataShards and ParityShards enable erasure coding when ParityShards > 0. Every DataShards blobs are followed
+	// by ParityShards parity blobs, and any DataShards of them are enough to rebuild the data.
+	DataShards   int
+	ParityShards int
+	// Compression is applied to the whole file before splitting it in blobs
+	Compression Compression
+	// Recipients are the X25519 public keys the file is encrypted for. The file is not encrypted if empty.
+	Recipients [][]byte
+	// Filename and ContentType are stored in the manifest
+	Filename    string
+	ContentType string
+	// Archive is the index of a directory upload, stored in the manifest
+	Archive []ArchiveEntry
+	// Concurrency is the number of blobs whose commitments and proofs are computed in parallel (number of CPUs if 0)
+	Concurrency int
+	// Seed is shared by all the blobs of the file. A new one is generated if empty.
+	Seed []byte
+}
+
+// flags returns the header flags matching the options
+func (opts EncodeOptions) flags() byte {
+	flags := headerFlagLength
+	if opts.Dense {
+		flags |= headerFlagDense
+	}
+	if opts.ParityShards > 0 {
+		flags |= headerFlagErasure
+	}
+	if len(opts.Recipients) > 0 {
+		flags |= headerFlagEncrypted
+	}
+	return flags
+}
+
+type MagicHeader struct {
+	Version    byte
+	Flags      byte
+	BlobIndex  int
+	TotalBlobs int
+	Seed       []byte
+	// Length is the number of bytes of data stored in this blob
+	Length int
+	// FileSize is the number of bytes of the whole file
+	FileSize uint64
+	// Erasure is only set when the file is erasure coded
+	Erasure erasureGeometry
+	// Compression is the algorithm used to compress the file. FileSize is the size after compression.
+	Compression Compression
+}
+
+// headerFieldElements returns how many field elements at the beginning of a blob are used by the magic header
+func headerFieldElements(version byte) int {
+	if version == magicHeaderV1 {
+		return 1
+	}
+	return 2
+}
+
+// payloadCapacity returns the number of bytes of data that fit in `fieldElements` field elements
+func payloadCapacity(fieldElements int, flags byte) int {
+	if flags&headerFlagDense != 0 {
+		return denseCapacity(fieldElements)
+	}
+	return fieldElements * 31
+}
+
+// blobCapacity returns the number of bytes of data that fit in a blob after a magic header of the given version
+func blobCapacity(version, flags byte) int {
+	return payloadCapacity(params.BlobTxFieldElementsPerBlob-headerFieldElements(version), flags)
+}
+
+// encodePayload writes data to the blob starting at field element `offset` with the codec selected by flags
+func encodePayload(blob *kzg4844.Blob, offset int, data []byte, flags byte) {
+	if flags&headerFlagDense != 0 {
+		encodeDenseFieldElements(blob, offset, data)
+	} else {
+		encodeFieldElements(blob, offset, data)
+	}
+}
+
+// decodePayload reads all the data from field element `offset` until the end of the blob with the codec selected by flags
+func decodePayload(blob []byte, offset int, flags byte) []byte {
+	if flags&headerFlagDense != 0 {
+		return decodeDenseFieldElements(blob, offset)
+	}
+	return decodeFieldElements(blob, offset)
+}
+
+// chunkCapacity returns the number of bytes of the file that fit in a blob
+func chunkCapacity(opts EncodeOptions) int {
+	capacity := blobCapacity(magicHeaderV2, opts.flags())
+	if opts.ParityShards > 0 {
+		capacity -= erasureLengthPrefix
+	}
+	return capacity
+}
+
+// countBlobs returns the number of blobs needed to send a file of `size` bytes, manifest and parity blobs included
+func countBlobs(size int, opts EncodeOptions) int {
+	capacity := chunkCapacity(opts)
+	chunks := (size + capacity - 1) / capacity
+	if size == 0 {
+		chunks = 1
 	}
-	fmt.Printf("File size is %d bytes and will be split into %d blobs of 128KB\n", fileSize, totalBlobs)
-	return totalBlobs
+	blobs := 1 + digestBlobs(chunks, capacity) + chunks
+	if opts.ParityShards > 0 {
+		blobs = erasureGeometry{DataShards: opts.DataShards, ParityShards: opts.ParityShards, DataBlobs: blobs}.totalBlobs()
+	}
+	return blobs
 }
 
 // TODO: func copyMagicHeader()
-// TODO: Pre-calculate total cost of sending file (single or multipart)
-// encodeMultipartBlobs does encode `blobsPerTx` blobs in one transaction. Blobs will contain a magic header that allows
+// encodeBlobsWithMagicHeader splits data in blobs. Blobs will contain a magic header that allows
 // identifying different pieces of the files. The magic header also contains the blob number and total of blobs.
-func encodeBlobsWithMagicHeader(data []byte) []kzg4844.Blob {
-	blobs := []kzg4844.Blob{{}}
-
-	totalBlobs := getTotalBlobs(data)
-	if totalBlobs > 255 {
-		fmt.Println("More than 255 blobs is not supported in this version")
-		return blobs
+// The first blob holds the manifest of the file, which is used to verify it after downloading.
+func encodeBlobsWithMagicHeader(data []byte, opts EncodeOptions) ([]kzg4844.Blob, error) {
+	enc, err := newBlobEncoder(bytes.NewReader(data), opts)
+	if err != nil {
+		return nil, err
 	}
+	defer enc.Close()
 
-	blobIndex := 0
-	fieldIndex := 0
-	seed := uint64(time.Now().UnixNano())
-	magicHeader := generateMagicHeader(blobIndex, totalBlobs, seed)
-	copy(blobs[blobIndex][fieldIndex*32:], magicHeader)
-	// fmt.Printf("%d %d %x || %d\n", 0, fieldIndex, magicHeader, magicHeader)
-	fieldIndex++
+	blobs := make([]kzg4844.Blob, 0, enc.TotalBlobs())
+	for {
+		blob, err := enc.Next()
+		if err == io.EOF {
+			return blobs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, *blob)
+	}
+}
 
+// encodeFieldElements copies data to the blob 31 bytes at a time, starting at field element `offset`.
+// The first byte of every field element is left to zero so that it is always a canonical scalar.
+func encodeFieldElements(blob *kzg4844.Blob, offset int, data []byte) {
+	fieldIndex := offset
 	for i := 0; i < len(data); i += 31 {
-		if fieldIndex == params.BlobTxFieldElementsPerBlob {
-			blobs = append(blobs, kzg4844.Blob{})
-			blobIndex++
-			fieldIndex = 0
-			// fmt.Printf("---- BEGIN OF BLOB %d -----\n", blobIndex)
-
-			magicHeader := generateMagicHeader(blobIndex, totalBlobs, seed)
-			copy(blobs[blobIndex][fieldIndex*32:], magicHeader)
-			// fmt.Printf("%d %d %x || %d\n", 0, fieldIndex, magicHeader, magicHeader)
-			fieldIndex++
-		}
 		max := i + 31
 		if max > len(data) {
 			max = len(data)
 		}
-		// fmt.Println(i, fieldIndex, data[i:max])
-		// fmt.Printf("%d %d %x || %d\n", i, fieldIndex, data[i:max], data[i:max])
-		copy(blobs[blobIndex][fieldIndex*32+1:], data[i:max])
+		copy(blob[fieldIndex*32+1:], data[i:max])
 		fieldIndex++
 	}
+}
 
-	return blobs
+// decodeFieldElements is the inverse of encodeFieldElements. It returns the 31 bytes of data of every
+// field element from `offset` until the end of the blob.
+func decodeFieldElements(blob []byte, offset int) []byte {
+	data := make([]byte, 0, (params.BlobTxFieldElementsPerBlob-offset)*31)
+	for i := offset; i < params.BlobTxFieldElementsPerBlob; i++ {
+		data = append(data, blob[i*32+1:i*32+32]...)
+	}
+	return data
 }
 
-func encodeBlobs(data []byte) []kzg4844.Blob {
-	blobs := []kzg4844.Blob{{}}
-	blobIndex := 0
-	fieldIndex := -1
-	for i := 0; i < len(data); i += 31 {
-		fieldIndex++
-		if fieldIndex == params.BlobTxFieldElementsPerBlob {
-			blobs = append(blobs, kzg4844.Blob{})
-			blobIndex++
-			fieldIndex = 0
-		}
-		max := i + 31
-		if max > len(data) {
-			max = len(data)
+// rawBlobVersion is the version of the layout of blobs encoded by encodeBlobs. The first field element of every
+// blob holds it (byte 26), the flags (byte 27) and the length of the payload (bytes 28 to 31).
+// Blobs sent by tx1 before it was versioned have no header: their data starts in the first field element.
+const rawBlobVersion = 1
+
+// rawBlobCapacity returns the number of bytes of data that fit in a blob encoded by encodeBlobs
+func rawBlobCapacity(flags byte) int {
+	return payloadCapacity(params.BlobTxFieldElementsPerBlob-1, flags)
+}
+
+// rawBlobHeader returns the flags and the payload length of a blob encoded by encodeBlobs. ok is false if the
+// first field element is not a valid header.
+func rawBlobHeader(blob []byte) (flags byte, length int, ok bool) {
+	for _, b := range blob[:26] {
+		if b != 0 {
+			return 0, 0, false
 		}
-		copy(blobs[blobIndex][fieldIndex*32+1:], data[i:max])
 	}
-	return blobs
+	flags = blob[27]
+	if blob[26] != rawBlobVersion || flags&headerFlagLength == 0 || flags&^(headerFlagLength|headerFlagDense) != 0 {
+		return 0, 0, false
+	}
+	length = int(binary.BigEndian.Uint32(blob[28:32]))
+	if length > rawBlobCapacity(flags) {
+		return 0, 0, false
+	}
+	return flags, length, true
 }
 
-// magicHeader is a 32 bytes array containing a string we use to identify files splitted in multiple blobs
-// plus blobIndex and totalBlobs
-func generateMagicHeader(blobIndex, totalBlobs int, seed uint64) []byte {
+func encodeBlobs(data []byte, opts EncodeOptions) []kzg4844.Blob {
+	flags := opts.flags()
+	capacity := rawBlobCapacity(flags)
+
+	blobs := make([]kzg4844.Blob, 1+len(data)/capacity)
+	if len(data) > 0 && len(data)%capacity == 0 {
+		blobs = blobs[:len(blobs)-1]
+	}
 
-	randomBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(randomBytes, seed)
-	magicHeader := make([]byte, 32)
+	for blobIndex := range blobs {
+		start := blobIndex * capacity
+		end := start + capacity
+		if end > len(data) {
+			end = len(data)
+		}
+		blobs[blobIndex][26] = rawBlobVersion
+		blobs[blobIndex][27] = flags
+		binary.BigEndian.PutUint32(blobs[blobIndex][28:32], uint32(end-start))
+		encodePayload(&blobs[blobIndex], 1, data[start:end], flags)
+	}
+	return blobs
+}
 
-	copy(magicHeader, []byte{66, 108, 111, 98, 115, 65, 114, 101, 67, 111, 109, 105, 110, 103, 46, 1, 46, byte(blobIndex), 46, byte(totalBlobs), 46, 46, 46, 46})
-	copy(magicHeader[24:], randomBytes)
+// generateMagicHeader returns a v2 magic header. The first field element contains a string we use to identify
+// files splitted in multiple blobs, the header version and a seed shared by all the blobs of the same file.
+// The second field element contains blobIndex, totalBlobs, flags and the length of the payload and file.
+func generateMagicHeader(header *MagicHeader) []byte {
+	magicHeader := make([]byte, headerFieldElements(magicHeaderV2)*32)
+
+	copy(magicHeader, magicPrefix)
+	magicHeader[magicHeaderVersionOffset] = magicHeaderV2
+	copy(magicHeader[magicHeaderVersionOffset+1:magicHeaderSeedOffset], "........")
+	copy(magicHeader[magicHeaderSeedOffset:32], header.Seed)
+
+	binary.BigEndian.PutUint32(magicHeader[33:], uint32(header.BlobIndex))
+	binary.BigEndian.PutUint32(magicHeader[37:], uint32(header.TotalBlobs))
+	magicHeader[magicHeaderFlagsOffset] = header.Flags
+	binary.BigEndian.PutUint32(magicHeader[42:], uint32(header.Length))
+	binary.BigEndian.PutUint64(magicHeader[46:], header.FileSize)
+	if header.Flags&headerFlagErasure != 0 {
+		magicHeader[54] = byte(header.Erasure.DataShards)
+		magicHeader[55] = byte(header.Erasure.ParityShards)
+		binary.BigEndian.PutUint32(magicHeader[56:], uint32(header.Erasure.DataBlobs))
+	}
+	magicHeader[60] = byte(header.Compression)
 
 	//fmt.Printf("Magic header (len=%d): %v\n", len(magicHeader), magicHeader)
 	return magicHeader
 }
 
+// hasMagicHeader reports whether the blob starts with the magic prefix
+func hasMagicHeader(blob []byte) bool {
+	return bytes.HasPrefix(blob, magicPrefix)
+}
+
+// parseMagicHeader reads the magic header of a blob. Both v1 and v2 headers are supported.
+func parseMagicHeader(blob []byte) (*MagicHeader, error) {
+	if len(blob) != params.BlobTxFieldElementsPerBlob*32 {
+		return nil, fmt.Errorf("invalid blob length %d", len(blob))
+	}
+	if !hasMagicHeader(blob) {
+		return nil, fmt.Errorf("blob does not contain magic header")
+	}
+
+	header := &MagicHeader{
+		Version: blob[magicHeaderVersionOffset],
+		Seed:    common.CopyBytes(blob[magicHeaderSeedOffset:32]),
+	}
+
+	switch header.Version {
+	case magicHeaderV1:
+		header.BlobIndex = int(blob[17])
+		header.TotalBlobs = int(blob[19])
+	case magicHeaderV2:
+		header.BlobIndex = int(binary.BigEndian.Uint32(blob[33:]))
+		header.TotalBlobs = int(binary.BigEndian.Uint32(blob[37:]))
+		header.Flags = blob[magicHeaderFlagsOffset]
+		if header.Flags&headerFlagLength != 0 {
+			header.Length = int(binary.BigEndian.Uint32(blob[42:]))
+			header.FileSize = binary.BigEndian.Uint64(blob[46:])
+			if header.Length > blobCapacity(header.Version, header.Flags) {
+				return nil, fmt.Errorf("invalid payload length %d", header.Length)
+			}
+		}
+		if header.Flags&headerFlagErasure != 0 {
+			header.Erasure = erasureGeometry{
+				DataShards:   int(blob[54]),
+				ParityShards: int(blob[55]),
+				DataBlobs:    int(binary.BigEndian.Uint32(blob[56:])),
+			}
+			if err := validateErasureOptions(header.Erasure.DataShards, header.Erasure.ParityShards); err != nil {
+				return nil, err
+			}
+			if header.Erasure.totalBlobs() != header.TotalBlobs {
+				return nil, fmt.Errorf("erasure coding expects %d blobs but header contains %d", header.Erasure.totalBlobs(), header.TotalBlobs)
+			}
+		}
+		header.Compression = Compression(blob[60])
+		if header.Compression > CompressionZstd {
+			return nil, fmt.Errorf("unsupported compression algorithm %d", header.Compression)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported magic header version %d", header.Version)
+	}
+	return header, nil
+}
+
 // EncodeMultipartBlob packs blob txs and sends them through the blobChannel,
 // ready to be broadcasted.
 // The main difference between EncodeBlobs and EncodeMultipartBlobs are...
 // 1. Adds magic header
 // 2. Sends them through channel instead of returning so that it can be right broadcasted
-func EncodeMultipartBlob(blobChannel chan<- FullBlobStruct, data []byte, blobsPerTx int) {
+// The channel is closed when it returns, and a non-nil error means the blobs sent are not the whole file.
+// It stops when ctx is cancelled, e.g. when the upload failed and nothing reads the channel anymore.
+func EncodeMultipartBlob(ctx context.Context, blobChannel chan<- FullBlobStruct, r io.Reader, blobsPerTx int, opts EncodeOptions) error {
+	defer close(blobChannel)
+
 	var (
-		allBlobs        = encodeBlobsWithMagicHeader(data)
 		blobs           []kzg4844.Blob
 		commits         []kzg4844.Commitment
 		proofs          []kzg4844.Proof
 		versionedHashes []common.Hash
+		txIndex         int
 	)
 
-	if blobsPerTx > 8 {
-		fmt.Println("Max blobs per transaction is 6")
-		close(blobChannel)
-		return
+	if blobsPerTx < 1 || blobsPerTx > int(maxBlobsPerBlock) {
+		return fmt.Errorf("invalid blobs per tx %d, a block holds at most %d blobs", blobsPerTx, maxBlobsPerBlock)
 	}
 
-	uploadSeconds := len(allBlobs)/blobsPerTx*12 + 12
-	fmt.Printf("Total blobs: %d. Approximate upload time: %d seconds at %d blobs per tx\n", len(allBlobs), uploadSeconds, blobsPerTx)
-
-	for blobIndex, blob := range allBlobs {
-		blobs = append(blobs, blob)
-		commit, err := kzg4844.BlobToCommitment(blob)
-		if err != nil {
-			fmt.Println(err)
-			return
+	// Blobs are encoded as they are sent, so only one transaction and the blobs being computed are held in memory
+	enc, err := newBlobEncoder(r, opts)
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	uploadSeconds := enc.TotalBlobs()/blobsPerTx*12 + 12
+	fmt.Printf("Total blobs: %d. Approximate upload time: %d seconds at %d blobs per tx\n", enc.TotalBlobs(), uploadSeconds, blobsPerTx)
+
+	concurrency := kzgConcurrency(opts.Concurrency)
+	for done := false; !done; {
+		// Read as many blobs as there are workers to compute their commitments and proofs in parallel
+		var batch []kzg4844.Blob
+		for len(batch) < concurrency {
+			blob, err := enc.Next()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			batch = append(batch, *blob)
+		}
+		if len(batch) == 0 {
+			break
 		}
-		commits = append(commits, commit)
 
-		proof, err := kzg4844.ComputeBlobProof(blob, commit)
+		sidecar, hashes, err := newSidecar(batch, concurrency)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return err
 		}
-		proofs = append(proofs, proof)
-		versionedHashes = append(versionedHashes, kZGToVersionedHash(commit))
+		blobs = append(blobs, sidecar.Blobs...)
+		commits = append(commits, sidecar.Commitments...)
+		proofs = append(proofs, sidecar.Proofs...)
+		versionedHashes = append(versionedHashes, hashes...)
 
-		if (blobIndex+1)%blobsPerTx == 0 {
+		for len(blobs) >= blobsPerTx {
 			sidecar := types.BlobTxSidecar{
-				Blobs:       blobs,
-				Commitments: commits,
-				Proofs:      proofs,
+				Blobs:       blobs[:blobsPerTx],
+				Commitments: commits[:blobsPerTx],
+				Proofs:      proofs[:blobsPerTx],
+			}
+			blobStruct := FullBlobStruct{Sidecar: sidecar, VersionedHashes: versionedHashes[:blobsPerTx], Index: txIndex}
+			select {
+			case blobChannel <- blobStruct:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			blobStruct := FullBlobStruct{Sidecar: sidecar, VersionedHashes: versionedHashes}
-			blobChannel <- blobStruct
-
-			// Reset
-			blobs = []kzg4844.Blob{}
-			commits = []kzg4844.Commitment{}
-			proofs = []kzg4844.Proof{}
-			versionedHashes = []common.Hash{}
+			txIndex++
+
+			// Keep the blobs of the next transaction
+			blobs = append([]kzg4844.Blob{}, blobs[blobsPerTx:]...)
+			commits = append([]kzg4844.Commitment{}, commits[blobsPerTx:]...)
+			proofs = append([]kzg4844.Proof{}, proofs[blobsPerTx:]...)
+			versionedHashes = append([]common.Hash{}, versionedHashes[blobsPerTx:]...)
 		}
 	}
 
@@ -210,41 +475,18 @@ func EncodeMultipartBlob(blobChannel chan<- FullBlobStruct, data []byte, blobsPe
 			Commitments: commits,
 			Proofs:      proofs,
 		}
-		blobStruct := FullBlobStruct{Sidecar: sidecar, VersionedHashes: versionedHashes}
-		blobChannel <- blobStruct
+		blobStruct := FullBlobStruct{Sidecar: sidecar, VersionedHashes: versionedHashes, Index: txIndex}
+		select {
+		case blobChannel <- blobStruct:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	close(blobChannel)
+	return nil
 }
 
-func EncodeBlobs(data []byte) (*types.BlobTxSidecar, []common.Hash, error) {
-	var (
-		blobs           = encodeBlobs(data)
-		commits         []kzg4844.Commitment
-		proofs          []kzg4844.Proof
-		versionedHashes []common.Hash
-	)
-
-	for _, blob := range blobs {
-		commit, err := kzg4844.BlobToCommitment(blob)
-		if err != nil {
-			return nil, nil, err
-		}
-		commits = append(commits, commit)
-
-		proof, err := kzg4844.ComputeBlobProof(blob, commit)
-		if err != nil {
-			return nil, nil, err
-		}
-		proofs = append(proofs, proof)
-
-		versionedHashes = append(versionedHashes, kZGToVersionedHash(commit))
-	}
-	sidecar := types.BlobTxSidecar{
-		Blobs:       blobs,
-		Commitments: commits,
-		Proofs:      proofs,
-	}
-	return &sidecar, versionedHashes, nil
+func EncodeBlobs(data []byte, opts EncodeOptions) (*types.BlobTxSidecar, []common.Hash, error) {
+	return newSidecar(encodeBlobs(data, opts), opts.Concurrency)
 }
 
 var blobCommitmentVersionKZG uint8 = 0x01
@@ -257,53 +499,60 @@ func kZGToVersionedHash(kzg kzg4844.Commitment) common.Hash {
 	return h
 }
 
-func DecodeMagicBlob(blob []byte) []byte {
-	if len(blob) != params.BlobTxFieldElementsPerBlob*32 {
-		fmt.Printf("len blob found: %d, expected: %d\n", len(blob), params.BlobTxFieldElementsPerBlob*32-33)
-		panic("invalid blob encoding")
+func DecodeMagicBlob(blob []byte) ([]byte, error) {
+	_, data, err := decodeMagicBlob(blob)
+	if err != nil {
+		return nil, fmt.Errorf("error reading magic header: %w", err)
 	}
-	var data []byte
+	return data, nil
+}
 
-	// XXX: the following removes trailing 0s in each field element (see EncodeBlobs), which could be unexpected for certain blobs
-	// And skips the magic header
-	j := 33
-	for i := 1; i < params.BlobTxFieldElementsPerBlob; i++ {
-		data = append(data, blob[j:j+31]...)
-		j += 32
+// decodeMagicBlob returns the header and the payload of a blob encoded by encodeBlobsWithMagicHeader
+func decodeMagicBlob(blob []byte) (*MagicHeader, []byte, error) {
+	header, err := parseMagicHeader(blob)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	i := len(data) - 1
-	for ; i >= 0; i-- {
-		if data[i] != 0x00 {
-			break
-		}
+	// Skip the magic header
+	data := decodePayload(blob, headerFieldElements(header.Version), header.Flags)
+	if header.Flags&headerFlagLength != 0 {
+		return header, data[:header.Length], nil
 	}
-	data = data[:i+1]
-	return data
+
+	// XXX: blobs without length remove trailing 0s in each field element (see EncodeBlobs), which could be unexpected for certain blobs
+	return header, trimTrailingZeros(data), nil
+}
+
+func DecodeBlob(blob []byte) ([]byte, error) {
+	_, data, err := decodeRawBlob(blob)
+	return data, err
 }
 
-func DecodeBlob(blob []byte) []byte {
+// decodeRawBlob returns the header and the payload of a blob encoded by encodeBlobs, or by tx1 before the layout
+// was versioned
+func decodeRawBlob(blob []byte) (*MagicHeader, []byte, error) {
 	if len(blob) != params.BlobTxFieldElementsPerBlob*32 {
-		fmt.Printf("len blob found: %d, expected: %d\n", len(blob), params.BlobTxFieldElementsPerBlob*32)
-		panic("invalid blob encoding")
+		return nil, nil, fmt.Errorf("len blob found: %d, expected: %d", len(blob), params.BlobTxFieldElementsPerBlob*32)
 	}
-	var data []byte
 
-	// XXX: the following removes trailing 0s in each field element (see EncodeBlobs), which could be unexpected for certain blobs
-	j := 0
-	for i := 0; i < params.BlobTxFieldElementsPerBlob; i++ {
-		data = append(data, blob[j:j+31]...)
-		j += 32
+	flags, length, ok := rawBlobHeader(blob)
+	if !ok {
+		// XXX: unversioned blobs remove trailing 0s, which could be unexpected for certain blobs
+		data := trimTrailingZeros(decodeFieldElements(blob, 0))
+		return &MagicHeader{TotalBlobs: 1, Length: len(data)}, data, nil
 	}
+	return &MagicHeader{Flags: flags, TotalBlobs: 1, Length: length}, decodePayload(blob, 1, flags)[:length], nil
+}
 
+func trimTrailingZeros(data []byte) []byte {
 	i := len(data) - 1
 	for ; i >= 0; i-- {
 		if data[i] != 0x00 {
 			break
 		}
 	}
-	data = data[:i+1]
-	return data
+	return data[:i+1]
 }
 
 func DecodeUint256String(hexOrDecimal string) (*uint256.Int, error) {
@@ -1,5 +1,16 @@
 package main
 
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/ethereum/go-ethereum/params"
+)
+
 func makeBlob(siz int) []byte {
 	b := make([]byte, siz)
 	for i := range b {
@@ -8,21 +19,24 @@ func makeBlob(siz int) []byte {
 	return b
 }
 
-/*
 func TestBlobCodec(t *testing.T) {
 	blobs := [][]byte{
 		makeBlob(0),
 		makeBlob(5),
 		makeBlob(95),
-		makeBlob(params.FieldElementsPerBlob * 31),
+		make([]byte, 95),
+		makeBlob(rawBlobCapacity(headerFlagLength)),
 	}
 	for i, blob := range blobs {
-		encB := EncodeBlobs(blob)
+		encB := encodeBlobs(blob, EncodeOptions{})
 		if len(encB) != 1 {
 			t.Fatalf("(%d) expected 1 blob, got %d", i, len(encB))
 		}
 		enc := encB[0]
-		dec := DecodeBlob(enc[:])
+		dec, err := DecodeBlob(enc[:])
+		if err != nil {
+			t.Fatal(err)
+		}
 		if len(dec) != len(blob) {
 			t.Fatalf("(%d) mismatched lengths: expected %d, got %d", i, len(blob), len(dec))
 		}
@@ -33,13 +47,19 @@ func TestBlobCodec(t *testing.T) {
 }
 
 func TestBlobsCodec(t *testing.T) {
-	blob := makeBlob(params.FieldElementsPerBlob*32 + 10)
-	encB := EncodeBlobs(blob)
+	blob := makeBlob(params.BlobTxFieldElementsPerBlob*32 + 10)
+	encB := encodeBlobs(blob, EncodeOptions{})
 	if len(encB) != 2 {
 		t.Fatal("expected 2 blobs, got", len(encB))
 	}
-	dec1 := DecodeBlob(encB[0][:])
-	dec2 := DecodeBlob(encB[1][:])
+	dec1, err := DecodeBlob(encB[0][:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec2, err := DecodeBlob(encB[1][:])
+	if err != nil {
+		t.Fatal(err)
+	}
 	dec := append(dec1, dec2...)
 	if len(dec) != len(blob) {
 		t.Fatalf("mismatched lengths: expected %d, got %d", len(blob), len(dec))
@@ -48,4 +68,253 @@ func TestBlobsCodec(t *testing.T) {
 		t.Fatalf("expected %x, got %x", blob, dec)
 	}
 }
-*/
\ No newline at end of file
+
+// roundTripInputs returns byte strings that are easy to corrupt by guessing the payload length
+func roundTripInputs() [][]byte {
+	random := make([]byte, 3*rawBlobCapacity(headerFlagLength)/2)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	trailingZeros := makeBlob(1000)
+	for i := 900; i < len(trailingZeros); i++ {
+		trailingZeros[i] = 0
+	}
+
+	return [][]byte{
+		{},
+		{0},
+		make([]byte, 31),
+		make([]byte, 2*rawBlobCapacity(headerFlagLength)+7),
+		trailingZeros,
+		random,
+		makeBlob(blobCapacity(magicHeaderV2, headerFlagLength)),
+		makeBlob(blobCapacity(magicHeaderV2, headerFlagLength|headerFlagDense) + 1),
+	}
+}
+
+var encodeOptionsTests = []EncodeOptions{
+	{},
+	{Dense: true},
+}
+
+func TestBlobsCodecRoundTrip(t *testing.T) {
+	for _, opts := range encodeOptionsTests {
+		for i, data := range roundTripInputs() {
+			var dec []byte
+			for _, blob := range encodeBlobs(data, opts) {
+				payload, err := DecodeBlob(blob[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec = append(dec, payload...)
+			}
+			if !bytes.Equal(data, dec) {
+				t.Fatalf("(%d, %+v) round trip failed: expected %d bytes, got %d", i, opts, len(data), len(dec))
+			}
+		}
+	}
+}
+
+func TestMagicBlobsCodecRoundTrip(t *testing.T) {
+	for _, opts := range encodeOptionsTests {
+		for i, data := range roundTripInputs() {
+			blobs, err := encodeBlobsWithMagicHeader(data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var dec []byte
+			var verifier *manifestVerifier
+			for _, blob := range blobs {
+				header, err := parseMagicHeader(blob[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.FileSize != uint64(len(data)) {
+					t.Fatalf("(%d, %+v) expected file size %d, got %d", i, opts, len(data), header.FileSize)
+				}
+				payload, err := DecodeMagicBlob(blob[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Flags&headerFlagManifest != 0 {
+					manifest, err := decodeManifest(payload)
+					if err != nil {
+						t.Fatal(err)
+					}
+					verifier = newManifestVerifier(manifest)
+					continue
+				}
+				if err := verifier.Write(payload); err != nil {
+					t.Fatal(err)
+				}
+				dec = append(dec, payload...)
+			}
+			if err := verifier.Verify(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, dec) {
+				t.Fatalf("(%d, %+v) round trip failed: expected %d bytes, got %d", i, opts, len(data), len(dec))
+			}
+		}
+	}
+}
+
+func TestDenseCodecCanonical(t *testing.T) {
+	data := bytes.Repeat([]byte{0xff}, rawBlobCapacity(headerFlagDense))
+	blobs := encodeBlobs(data, EncodeOptions{Dense: true})
+	if len(blobs) != 1 {
+		t.Fatalf("expected 1 blob, got %d", len(blobs))
+	}
+	if _, err := kzg4844.BlobToCommitment(blobs[0]); err != nil {
+		t.Fatalf("dense blob is not canonical: %v", err)
+	}
+	if rawBlobCapacity(headerFlagDense) <= rawBlobCapacity(0) {
+		t.Fatalf("dense codec does not increase capacity")
+	}
+}
+
+func TestMagicHeaderV2(t *testing.T) {
+	var blob kzg4844.Blob
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, 42)
+	copy(blob[:], generateMagicHeader(&MagicHeader{
+		Flags:      headerFlagLength,
+		BlobIndex:  300,
+		TotalBlobs: 70000,
+		Seed:       seed,
+		Length:     1234,
+		FileSize:   1 << 40,
+	}))
+
+	header, err := parseMagicHeader(blob[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != magicHeaderV2 || header.BlobIndex != 300 || header.TotalBlobs != 70000 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if header.Length != 1234 || header.FileSize != 1<<40 {
+		t.Fatalf("unexpected lengths %+v", header)
+	}
+	if binary.LittleEndian.Uint64(header.Seed) != 42 {
+		t.Fatalf("unexpected seed %x", header.Seed)
+	}
+}
+
+func TestDecodeMagicBlobV1(t *testing.T) {
+	data := []byte("hello from a v1 blob")
+
+	// v1 header, as generated by previous versions
+	var blob kzg4844.Blob
+	copy(blob[:], []byte{66, 108, 111, 98, 115, 65, 114, 101, 67, 111, 109, 105, 110, 103, 46, 1, 46, 3, 46, 5, 46, 46, 46, 46})
+	encodeFieldElements(&blob, 1, data)
+
+	header, err := parseMagicHeader(blob[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != magicHeaderV1 || header.BlobIndex != 3 || header.TotalBlobs != 5 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if dec, err := DecodeMagicBlob(blob[:]); err != nil || !bytes.Equal(dec, data) {
+		t.Fatalf("expected %x, got %x (%v)", data, dec, err)
+	}
+}
+
+// Blobs sent by tx1 before the raw layout was versioned start with data and are still decoded
+func TestDecodeBlobUnversioned(t *testing.T) {
+	data := []byte("hello from an unversioned blob, long enough to fill the first field element")
+
+	var blob kzg4844.Blob
+	encodeFieldElements(&blob, 0, data)
+	if (rawCodec{}).Detect(blob[:]) {
+		t.Fatal("unversioned blob detected as raw")
+	}
+	if dec, err := DecodeBlob(blob[:]); err != nil || !bytes.Equal(dec, data) {
+		t.Fatalf("expected %x, got %x (%v)", data, dec, err)
+	}
+
+	// Invalid blobs return errors instead of panicking
+	if _, err := DecodeBlob(blob[:100]); err == nil {
+		t.Fatal("expected error for a short blob")
+	}
+	if _, err := DecodeMagicBlob(blob[:]); err == nil {
+		t.Fatal("expected error for a blob without magic header")
+	}
+}
+
+func TestManifestVerifierMismatch(t *testing.T) {
+	data := makeBlob(1000)
+	chunks := [][]byte{data[:600], data[600:]}
+	manifest, err := readManifest(bytes.NewReader(data), 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := newManifestVerifier(manifest)
+	if err := verifier.Write(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := common.CopyBytes(chunks[1])
+	corrupted[0] ^= 0xff
+	if err := verifier.Write(corrupted); err == nil {
+		t.Fatal("expected error on corrupted chunk")
+	}
+
+	verifier = newManifestVerifier(manifest)
+	if err := verifier.Write(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.Verify(); err == nil {
+		t.Fatal("expected error on missing chunk")
+	}
+}
+
+// The chunk digests of files with too many chunks are stored in blobs after the manifest
+func TestManifestDigestBlobs(t *testing.T) {
+	const capacity = 2048
+	manifest, err := readManifest(bytes.NewReader(makeBlob(5000)), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads, err := encodeManifest(manifest, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blobs := digestBlobs(manifest.Chunks, capacity); blobs != 8 || len(payloads) != 1+blobs {
+		t.Fatalf("expected 8 blobs of digests, got %d and %d payloads", blobs, len(payloads))
+	}
+
+	decoded, err := decodeManifest(payloads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range payloads[1:] {
+		if decoded.complete() {
+			t.Fatal("manifest complete before all its blobs")
+		}
+		if err := decoded.addDigests(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !decoded.complete() || decoded.DigestBlobs != 8 {
+		t.Fatalf("unexpected manifest %d/%d digests in %d blobs", len(decoded.ChunkDigests), decoded.Chunks, decoded.DigestBlobs)
+	}
+	for i := range manifest.ChunkDigests {
+		if decoded.ChunkDigests[i] != manifest.ChunkDigests[i] {
+			t.Fatalf("digest %d differs", i)
+		}
+	}
+	if err := decoded.addDigests(payloads[1]); err == nil {
+		t.Fatal("expected error for more digests than chunks")
+	}
+
+	// Small files keep their digests in the manifest
+	small, err := readManifest(bytes.NewReader(makeBlob(100)), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payloads, err := encodeManifest(small, capacity); err != nil || len(payloads) != 1 {
+		t.Fatalf("expected a single manifest blob, got %d (%v)", len(payloads), err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+	"github.com/urfave/cli"
+)
+
+// SidecarBundle is a blob sidecar saved as JSON. VersionedHashes is optional.
+type SidecarBundle struct {
+	Blobs           []hexutil.Bytes `json:"blobs"`
+	Commitments     []hexutil.Bytes `json:"commitments"`
+	Proofs          []hexutil.Bytes `json:"proofs"`
+	VersionedHashes []hexutil.Bytes `json:"versionedHashes,omitempty"`
+}
+
+// sidecarItem is a blob of a sidecar with the commitment and proof that came with it
+type sidecarItem struct {
+	Index         int
+	Blob          []byte
+	Commitment    []byte
+	Proof         []byte
+	VersionedHash []byte // nil if unknown
+}
+
+// readSidecarFile reads a sidecar bundle or a beacon node blob_sidecars response
+func readSidecarFile(r io.Reader) ([]sidecarItem, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid sidecar file: %w", err)
+	}
+	if _, ok := fields["data"]; ok {
+		return readBeaconSidecars(data)
+	}
+	return readSidecarBundle(data)
+}
+
+func readBeaconSidecars(data []byte) ([]sidecarItem, error) {
+	var response BlobResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("invalid blob_sidecars response: %w", err)
+	}
+	items := make([]sidecarItem, len(response.Data))
+	for i, sidecar := range response.Data {
+		index := i
+		if sidecar.Index != "" {
+			var err error
+			if index, err = strconv.Atoi(sidecar.Index); err != nil {
+				return nil, fmt.Errorf("invalid blob index %q", sidecar.Index)
+			}
+		}
+		item := sidecarItem{Index: index}
+		for _, field := range []struct {
+			name string
+			hex  string
+			dst  *[]byte
+		}{
+			{"blob", sidecar.Blob, &item.Blob},
+			{"kzg_commitment", sidecar.KZGCommitment, &item.Commitment},
+			{"kzg_proof", sidecar.KZGProof, &item.Proof},
+		} {
+			b, err := hexutil.Decode(field.hex)
+			if err != nil {
+				return nil, fmt.Errorf("blob %d: invalid %s: %w", index, field.name, err)
+			}
+			*field.dst = b
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+func readSidecarBundle(data []byte) ([]sidecarItem, error) {
+	var bundle SidecarBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid sidecar bundle: %w", err)
+	}
+	if len(bundle.Commitments) != len(bundle.Blobs) || len(bundle.Proofs) != len(bundle.Blobs) {
+		return nil, fmt.Errorf("sidecar bundle has %d blobs, %d commitments and %d proofs",
+			len(bundle.Blobs), len(bundle.Commitments), len(bundle.Proofs))
+	}
+	if len(bundle.VersionedHashes) != 0 && len(bundle.VersionedHashes) != len(bundle.Blobs) {
+		return nil, fmt.Errorf("sidecar bundle has %d blobs and %d versioned hashes", len(bundle.Blobs), len(bundle.VersionedHashes))
+	}
+	items := make([]sidecarItem, len(bundle.Blobs))
+	for i := range bundle.Blobs {
+		items[i] = sidecarItem{
+			Index:      i,
+			Blob:       bundle.Blobs[i],
+			Commitment: bundle.Commitments[i],
+			Proof:      bundle.Proofs[i],
+		}
+		if len(bundle.VersionedHashes) != 0 {
+			items[i].VersionedHash = bundle.VersionedHashes[i]
+		}
+	}
+	return items, nil
+}
+
+// verifySidecar checks the commitments, proofs and versioned hashes of a sidecar and returns every mismatch found.
+// Blobs that cannot be decoded or committed to are mismatches too.
+func verifySidecar(items []sidecarItem) []string {
+	var (
+		mismatches  []string
+		blobs       []kzg4844.Blob
+		commitments []kzg4844.Commitment
+		proofs      []kzg4844.Proof
+		checked     []sidecarItem
+	)
+	for _, item := range items {
+		if len(item.Blob) != len(kzg4844.Blob{}) {
+			mismatches = append(mismatches, fmt.Sprintf("blob %d: invalid blob size %d", item.Index, len(item.Blob)))
+			continue
+		}
+		if len(item.Commitment) != len(kzg4844.Commitment{}) {
+			mismatches = append(mismatches, fmt.Sprintf("blob %d: invalid commitment size %d", item.Index, len(item.Commitment)))
+			continue
+		}
+		if len(item.Proof) != len(kzg4844.Proof{}) {
+			mismatches = append(mismatches, fmt.Sprintf("blob %d: invalid proof size %d", item.Index, len(item.Proof)))
+			continue
+		}
+
+		var blob kzg4844.Blob
+		copy(blob[:], item.Blob)
+		commitment, err := kzg.BlobToCommitment(blob)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("blob %d: %v", item.Index, err))
+			continue
+		}
+		if !bytes.Equal(commitment[:], item.Commitment) {
+			mismatches = append(mismatches, fmt.Sprintf("blob %d: commitment %x does not match the blob, expected %x",
+				item.Index, item.Commitment, commitment))
+		}
+		if item.VersionedHash != nil {
+			if hash := kZGToVersionedHash(commitment); !bytes.Equal(hash[:], item.VersionedHash) {
+				mismatches = append(mismatches, fmt.Sprintf("blob %d: versioned hash %x does not match the blob, expected %s",
+					item.Index, item.VersionedHash, hash))
+			}
+		}
+
+		blobs = append(blobs, blob)
+		commitments = append(commitments, kzg4844.Commitment(*(*[48]byte)(item.Commitment)))
+		proofs = append(proofs, kzg4844.Proof(*(*[48]byte)(item.Proof)))
+		checked = append(checked, item)
+	}
+
+	if len(checked) == 0 {
+		return mismatches
+	}
+	if kzg.VerifyBlobProofBatch(blobs, commitments, proofs) != nil {
+		// The batch only tells that a proof is wrong, check them one by one to know which
+		for i, item := range checked {
+			if err := kzg.VerifyBlobProof(blobs[i], commitments[i], proofs[i]); err != nil {
+				mismatches = append(mismatches, fmt.Sprintf("blob %d: invalid proof %x: %v", item.Index, item.Proof, err))
+			}
+		}
+	}
+	return mismatches
+}
+
+// VerifyApp checks a sidecar saved to disk without a node
+func VerifyApp(cliCtx *cli.Context) error {
+	if err := setupKZG(cliCtx); err != nil {
+		return err
+	}
+	file, err := openBlobFile(cliCtx.String(VerifyFileFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	items, err := readSidecarFile(file)
+	if err != nil {
+		return err
+	}
+	mismatches := verifySidecar(items)
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d mismatches in %d blobs", len(mismatches), len(items))
+	}
+	fmt.Printf("All %d blobs verified\n", len(items))
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestVerifySidecar(t *testing.T) {
+	sidecar, hashes, err := EncodeBlobs(makeBlob(2*rawBlobCapacity(headerFlagLength)), EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle SidecarBundle
+	for i := range sidecar.Blobs {
+		bundle.Blobs = append(bundle.Blobs, sidecar.Blobs[i][:])
+		bundle.Commitments = append(bundle.Commitments, sidecar.Commitments[i][:])
+		bundle.Proofs = append(bundle.Proofs, sidecar.Proofs[i][:])
+		bundle.VersionedHashes = append(bundle.VersionedHashes, hashes[i][:])
+	}
+	verify := func(v interface{}) []string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := readSidecarFile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verifySidecar(items)
+	}
+
+	if mismatches := verify(bundle); len(mismatches) != 0 {
+		t.Fatalf("unexpected mismatches: %v", mismatches)
+	}
+
+	// The same sidecar as returned by a beacon node, with the proof of blob 1 swapped for the one of blob 0
+	var response BlobResponse
+	for i := range sidecar.Blobs {
+		response.Data = append(response.Data, BlobSidecar{
+			Index:         []string{"3", "4"}[i],
+			Blob:          hexutil.Encode(sidecar.Blobs[i][:]),
+			KZGCommitment: hexutil.Encode(sidecar.Commitments[i][:]),
+			KZGProof:      hexutil.Encode(sidecar.Proofs[0][:]),
+		})
+	}
+	if mismatches := verify(response); len(mismatches) != 1 || !strings.HasPrefix(mismatches[0], "blob 4: invalid proof") {
+		t.Fatalf("unexpected mismatches: %v", mismatches)
+	}
+
+	// A wrong versioned hash and a truncated blob are both reported
+	bundle.VersionedHashes[0] = hashes[1][:]
+	bundle.Blobs[1] = bundle.Blobs[1][:100]
+	if mismatches := verify(bundle); len(mismatches) != 2 {
+		t.Fatalf("unexpected mismatches: %v", mismatches)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	minReceiptPoll = 1 * time.Second
+	maxReceiptPoll = slotTime
+)
+
+// receiptClient is the part of ethclient.Client used to wait for receipts
+type receiptClient interface {
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// receiptWaiter waits for a transaction to be included and confirmed
+type receiptWaiter struct {
+	client receiptClient
+	// timeout bounds the whole wait, 0 waits until the context is done
+	timeout time.Duration
+	// confirmations is the number of blocks, including its own, that must hold the transaction
+	confirmations uint64
+	// bumpAfter is the number of blocks without inclusion after which the transaction is replaced with bump,
+	// 0 never replaces it
+	bumpAfter uint64
+	// maxBumps is the number of replacements after which the last one is waited for without raising its fees again
+	maxBumps int
+	bump     func(*types.Transaction) (*types.Transaction, error)
+
+	minPoll, maxPoll time.Duration
+}
+
+// errReorged is returned by check when the block of a receipt is no longer canonical
+var errReorged = errors.New("block reorged out")
+
+// wait returns the receipt of tx, or of one of the transactions that replaced it, once its block has the
+// requested confirmations. The poll interval doubles while nothing changes, up to maxPoll. A receipt whose block
+// is reorged out is reported and the wait goes on until the transaction is included again.
+func (w *receiptWaiter) wait(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	minPoll, maxPoll := w.minPoll, w.maxPoll
+	if minPoll == 0 {
+		minPoll = minReceiptPoll
+	}
+	if maxPoll < minPoll {
+		maxPoll = maxReceiptPoll
+	}
+
+	sent := []*types.Transaction{tx}
+	// Without the block the transaction was sent in, it would be replaced on the first check
+	sentBlock, err := w.blockNumber(ctx, minPoll)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		included *types.Receipt // receipt waiting for confirmations
+		reorged  common.Hash    // block of the last receipt reorged out, which the node may still return
+		poll     = minPoll
+	)
+	for {
+		var receipt *types.Receipt
+		notFound := true // every transaction was looked up and none is included
+		for _, tx := range sent {
+			r, err := w.client.TransactionReceipt(ctx, tx.Hash())
+			if err == nil && r.BlockHash == reorged {
+				continue
+			}
+			if err == nil {
+				receipt = r
+				break
+			}
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				// Some clients are treating the blobGasUsed as big.Int rather than uint64
+				return nil, err
+			}
+			if ctx.Err() != nil {
+				notFound = false
+				break
+			}
+			if err != ethereum.NotFound {
+				notFound = false
+				log.Printf("Error getting receipt of %v, retrying: %v", tx.Hash(), err)
+			}
+		}
+		if receipt == nil && included != nil && !notFound {
+			// The lookup failed, the block of the last receipt is checked instead
+			receipt = included
+		}
+
+		progress := false
+		if included != nil && (receipt == nil || receipt.BlockHash != included.BlockHash) {
+			log.Printf("Transaction %v was in block %d (%v), which was reorged out", included.TxHash, included.BlockNumber, included.BlockHash)
+			included, reorged, progress = nil, included.BlockHash, true
+		}
+		if receipt != nil {
+			if included == nil {
+				log.Printf("Transaction %v is in block %d (%v)", receipt.TxHash, receipt.BlockNumber, receipt.BlockHash)
+				included, progress = receipt, true
+			}
+			confirmed, err := w.check(ctx, receipt)
+			switch {
+			case errors.Is(err, errReorged):
+				log.Printf("Transaction %v was in block %d (%v), which was reorged out", receipt.TxHash, receipt.BlockNumber, receipt.BlockHash)
+				included, reorged, progress = nil, receipt.BlockHash, true
+			case err != nil:
+				if ctx.Err() == nil {
+					log.Printf("Error checking the block of %v, retrying: %v", receipt.TxHash, err)
+				}
+			case confirmed:
+				return receipt, nil
+			}
+		} else if included == nil && w.bumpAfter > 0 && len(sent) <= w.maxBumps && ctx.Err() == nil {
+			if head, err := w.client.BlockNumber(ctx); err == nil && head >= sentBlock+w.bumpAfter {
+				last := sent[len(sent)-1]
+				log.Printf("Transaction %v not included after %d blocks", last.Hash(), head-sentBlock)
+				if replacement, err := w.bump(last); err != nil {
+					log.Printf("Failed to replace transaction %v: %v", last.Hash(), err)
+				} else {
+					sent = append(sent, replacement)
+					if len(sent) > w.maxBumps {
+						log.Printf("Transaction %v is the last replacement (--max-bumps %d), its fees will not be raised again", replacement.Hash(), w.maxBumps)
+					}
+				}
+				sentBlock, progress = head, true
+			}
+		}
+
+		if progress {
+			poll = minPoll
+		}
+		select {
+		case <-ctx.Done():
+			if included != nil {
+				return nil, fmt.Errorf("transaction %v in block %d not confirmed: %w", included.TxHash, included.BlockNumber, ctx.Err())
+			}
+			return nil, fmt.Errorf("transaction %v not included: %w", sent[len(sent)-1].Hash(), ctx.Err())
+		case <-time.After(poll):
+		}
+		if poll *= 2; poll > maxPoll {
+			poll = maxPoll
+		}
+	}
+}
+
+// blockNumber returns the head of the chain, retrying every poll until the context is done
+func (w *receiptWaiter) blockNumber(ctx context.Context, poll time.Duration) (uint64, error) {
+	for {
+		head, err := w.client.BlockNumber(ctx)
+		if err == nil {
+			return head, nil
+		}
+		log.Printf("Error getting block number, retrying: %v", err)
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("error getting block number: %w", err)
+		case <-time.After(poll):
+		}
+	}
+}
+
+// check tells if the block of receipt has the requested confirmations, and returns errReorged if the block is not
+// canonical anymore
+func (w *receiptWaiter) check(ctx context.Context, receipt *types.Receipt) (bool, error) {
+	header, err := w.client.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return false, err
+	}
+	if header.Hash() != receipt.BlockHash {
+		return false, errReorged
+	}
+	confirmations := w.confirmations
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	head, err := w.client.BlockNumber(ctx)
+	if err != nil {
+		return false, err
+	}
+	return head+1 >= receipt.BlockNumber.Uint64()+confirmations, nil
+}
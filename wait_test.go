@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeChain is a receiptClient whose head and canonical blocks are changed by the test
+type fakeChain struct {
+	mu       sync.Mutex
+	head     uint64
+	blocks   map[uint64]*types.Header // canonical headers
+	receipts map[common.Hash]*types.Receipt
+	failures int // number of BlockNumber calls failing before the head is returned
+	// receiptFailures is the number of TransactionReceipt calls failing before the receipts are returned
+	receiptFailures int
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{blocks: map[uint64]*types.Header{}, receipts: map[common.Hash]*types.Receipt{}}
+}
+
+func (c *fakeChain) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.receiptFailures > 0 {
+		c.receiptFailures--
+		return nil, errors.New("connection reset")
+	}
+	if receipt, ok := c.receipts[hash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (c *fakeChain) BlockNumber(ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return 0, errors.New("connection refused")
+	}
+	return c.head, nil
+}
+
+func (c *fakeChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if header, ok := c.blocks[number.Uint64()]; ok {
+		return header, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+// include puts tx in a new canonical block at number, built with extra to get a different hash, and moves the head
+func (c *fakeChain) include(tx common.Hash, number uint64, extra string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := &types.Header{Number: new(big.Int).SetUint64(number), Difficulty: new(big.Int), Extra: []byte(extra)}
+	c.blocks[number] = header
+	c.receipts[tx] = &types.Receipt{TxHash: tx, BlockHash: header.Hash(), BlockNumber: header.Number}
+	if number > c.head {
+		c.head = number
+	}
+}
+
+func (c *fakeChain) setHead(head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head = head
+}
+
+func testWaiter(chain *fakeChain, confirmations uint64) *receiptWaiter {
+	return &receiptWaiter{
+		client:        chain,
+		timeout:       5 * time.Second,
+		confirmations: confirmations,
+		minPoll:       time.Millisecond,
+		maxPoll:       5 * time.Millisecond,
+	}
+}
+
+func TestReceiptWaiterConfirmations(t *testing.T) {
+	chain := newFakeChain()
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+	chain.include(tx.Hash(), 10, "a")
+
+	done := make(chan *types.Receipt)
+	go func() {
+		receipt, err := testWaiter(chain, 3).wait(context.Background(), tx)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- receipt
+	}()
+
+	chain.setHead(11)
+	select {
+	case <-done:
+		t.Fatal("receipt returned with 2 confirmations")
+	case <-time.After(50 * time.Millisecond):
+	}
+	chain.setHead(12)
+	if receipt := <-done; receipt == nil || receipt.BlockNumber.Uint64() != 10 {
+		t.Fatalf("unexpected receipt %v", receipt)
+	}
+}
+
+func TestReceiptWaiterReorg(t *testing.T) {
+	chain := newFakeChain()
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+	chain.include(tx.Hash(), 10, "a")
+	reorged := chain.receipts[tx.Hash()].BlockHash
+
+	done := make(chan *types.Receipt)
+	go func() {
+		receipt, err := testWaiter(chain, 2).wait(context.Background(), tx)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- receipt
+	}()
+
+	// Block 10 is replaced while the waiter waits for block 11, and the transaction moves to block 11
+	time.Sleep(20 * time.Millisecond)
+	chain.mu.Lock()
+	chain.blocks[10] = &types.Header{Number: big.NewInt(10), Difficulty: new(big.Int), Extra: []byte("b")}
+	chain.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	chain.include(tx.Hash(), 11, "b")
+	chain.setHead(12)
+
+	receipt := <-done
+	if receipt == nil || receipt.BlockHash == reorged || receipt.BlockNumber.Uint64() != 11 {
+		t.Fatalf("expected the receipt of block 11, got %v", receipt)
+	}
+}
+
+// A failed receipt lookup after inclusion is not a reorg, and the receipt is still confirmed
+func TestReceiptWaiterLookupError(t *testing.T) {
+	chain := newFakeChain()
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+	chain.include(tx.Hash(), 10, "a")
+
+	done := make(chan *types.Receipt)
+	go func() {
+		receipt, err := testWaiter(chain, 3).wait(context.Background(), tx)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- receipt
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	chain.mu.Lock()
+	chain.receiptFailures = 1
+	chain.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	chain.setHead(12)
+	if receipt := <-done; receipt == nil || receipt.BlockNumber.Uint64() != 10 {
+		t.Fatalf("unexpected receipt %v", receipt)
+	}
+}
+
+func TestReceiptWaiterTimeout(t *testing.T) {
+	chain := newFakeChain()
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+	waiter := testWaiter(chain, 1)
+	waiter.timeout = 20 * time.Millisecond
+	if _, err := waiter.wait(context.Background(), tx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestReceiptWaiterBump(t *testing.T) {
+	chain := newFakeChain()
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+	replacement := types.NewTx(&types.BlobTx{Nonce: 1, Gas: 1})
+	waiter := testWaiter(chain, 1)
+	waiter.bumpAfter = 2
+	waiter.maxBumps = 1
+	waiter.bump = func(last *types.Transaction) (*types.Transaction, error) {
+		if last.Hash() != tx.Hash() {
+			t.Errorf("unexpected transaction replaced %v", last.Hash())
+		}
+		// The replacement is included right away
+		chain.include(replacement.Hash(), 3, "a")
+		return replacement, nil
+	}
+
+	done := make(chan *types.Receipt)
+	go func() {
+		receipt, err := waiter.wait(context.Background(), tx)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- receipt
+	}()
+	time.Sleep(20 * time.Millisecond)
+	chain.setHead(2)
+	if receipt := <-done; receipt == nil || receipt.TxHash != replacement.Hash() {
+		t.Fatalf("expected the receipt of the replacement, got %v", receipt)
+	}
+}
+
+func TestReceiptWaiterMaxBumps(t *testing.T) {
+	chain := newFakeChain()
+	chain.head = 10
+	// The block the transaction is sent in is retried, not taken as 0
+	chain.failures = 2
+	tx := types.NewTx(&types.BlobTx{Nonce: 1})
+
+	var (
+		mu    sync.Mutex
+		bumps int
+	)
+	waiter := testWaiter(chain, 1)
+	waiter.timeout = 200 * time.Millisecond
+	waiter.bumpAfter = 1
+	waiter.maxBumps = 2
+	waiter.bump = func(last *types.Transaction) (*types.Transaction, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		bumps++
+		return types.NewTx(&types.BlobTx{Nonce: 1, Gas: uint64(bumps)}), nil
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := waiter.wait(context.Background(), tx)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if bumps != 0 {
+		t.Errorf("replaced before any new block")
+	}
+	mu.Unlock()
+	for head := uint64(11); head <= 15; head++ {
+		chain.setHead(head)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if bumps != 2 {
+		t.Fatalf("expected 2 replacements, got %d", bumps)
+	}
+}
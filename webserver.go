@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/urfave/cli"
@@ -16,6 +20,7 @@ import (
 /*
 Some examples:
 
+Any type: http://localhost:3333/stream/125754
 Empty: http://localhost:3333/stream/html?slot=125751
 Hello world: http://localhost:3333/stream/html?slot=125753
 Html: http://localhost:3333/stream/html?slot=125754
@@ -27,20 +32,25 @@ Image: http://localhost:3333/stream/html?slot=129252
 
 var globalUploadParams BlobUploadParams
 
+// streamHandler serves /stream/{slot} with the Content-Type stored in the manifest of the file
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	serveBlob(w, r, strings.TrimPrefix(r.URL.Path, "/stream/"), "")
+}
+
 func streamHtmlHandler(w http.ResponseWriter, r *http.Request) {
-	serveBlob(w, r, "text/html")
+	serveBlob(w, r, r.URL.Query().Get("slot"), "text/html")
 }
 
 func streamVideoHandler(w http.ResponseWriter, r *http.Request) {
-	serveBlob(w, r, "video/mp4")
+	serveBlob(w, r, r.URL.Query().Get("slot"), "video/mp4")
 }
 
 func streamSvgHandler(w http.ResponseWriter, r *http.Request) {
-	serveBlob(w, r, "image/svg+xml")
+	serveBlob(w, r, r.URL.Query().Get("slot"), "image/svg+xml")
 }
 
 func streamImageHandler(w http.ResponseWriter, r *http.Request) {
-	serveBlob(w, r, "image/jpeg")
+	serveBlob(w, r, r.URL.Query().Get("slot"), "image/jpeg")
 }
 
 func enableCORS(w http.ResponseWriter) {
@@ -49,8 +59,27 @@ func enableCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 }
 
+// setContentHeaders sets the Content-Type and Content-Disposition of a file from its manifest. Files uploaded
+// without metadata fall back to sniffing the first chunk.
+func setContentHeaders(w http.ResponseWriter, manifest *Manifest, firstChunk []byte) {
+	var filename string
+	if manifest != nil {
+		filename = manifest.Filename
+	}
+	contentType := detectContentType(filename, firstChunk)
+	if manifest != nil && manifest.ContentType != "" {
+		contentType = manifest.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if manifest != nil && manifest.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": manifest.Filename}))
+	}
+}
+
 // TODO: empty slot param
-func serveBlob(w http.ResponseWriter, r *http.Request, contentType string) {
+// serveBlob streams the file starting at slot. If contentType is empty, it is taken from the manifest of the file.
+func serveBlob(w http.ResponseWriter, r *http.Request, slot string, contentType string) {
 	enableCORS(w)
 
 	if r.Method == http.MethodOptions {
@@ -58,35 +87,66 @@ func serveBlob(w http.ResponseWriter, r *http.Request, contentType string) {
 		return
 	}
 
-	w.Header().Set("Content-Type", contentType)
-	w.(http.Flusher).Flush()
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+		w.(http.Flusher).Flush()
+	}
 
-	params, _ := url.ParseQuery(r.URL.RawQuery)
-	fmt.Println("params", params)
+	fmt.Println("slot", slot)
 
 	addr := "http://10.128.0.8:5052"
-	slot := params.Get("slot")
 
 	slotNumber, err := strconv.Atoi(slot)
 	if err != nil {
 		fmt.Println("Error:", err)
+		http.Error(w, "Invalid slot", http.StatusBadRequest)
 		return
 	}
 
 	blobChannel := make(chan []byte)
 
-	go GetMultiPartBlob(blobChannel, addr, slotNumber, false)
+	// The manifest is the first payload of the file, so it is always set before the first chunk is received
+	var manifest *Manifest
+	opts := DownloadOptions{
+		OnManifest: func(m *Manifest) {
+			manifest = m
+			// Files of a directory upload are served with their own name and type
+			if p := r.URL.Query().Get("path"); p != "" {
+				manifest = &Manifest{Filename: path.Base(p)}
+			}
+		},
+		Codec:   r.URL.Query().Get("codec"),
+		Path:    r.URL.Query().Get("path"),
+		Context: r.Context(),
+	}
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- GetMultiPartBlob(blobChannel, addr, slotNumber, opts)
+	}()
 
 	fmt.Println("Waiting for blobChannel...")
+	headersSent := contentType != ""
 	for {
 		select {
 		case result, ok := <-blobChannel:
 			if !ok {
+				if err := <-errChannel; err != nil {
+					fmt.Println("Error streaming blobs:", err)
+					// The status can only be changed before the first chunk is written
+					if !headersSent {
+						http.Error(w, "Error retrieving blobs", http.StatusBadGateway)
+					}
+					return
+				}
 				fmt.Println("All blobs received.")
 				return
 			}
 			fmt.Println("Blob received through channel")
 
+			if !headersSent {
+				setContentHeaders(w, manifest, result)
+				headersSent = true
+			}
 			w.Write(result)
 			w.(http.Flusher).Flush()
 		}
@@ -109,6 +169,27 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Several files are uploaded together as a directory
+	if parts := r.MultipartForm.File["file"]; len(parts) > 1 {
+		dir, err := saveUploadedFiles(parts)
+		if err != nil {
+			fmt.Println("Error saving files:", err)
+			http.Error(w, "Error creating files on server", http.StatusInternalServerError)
+			return
+		}
+
+		params := globalUploadParams
+		params.File = dir
+		params.EncodeOptions.Filename = r.FormValue("name")
+		initialSlot, err := MultipartUpload(params)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeUploadResponse(w, dir, initialSlot)
+		return
+	}
+
 	file, handler, err := r.FormFile("file")
 	if err != nil {
 		fmt.Println("Error retrieving file")
@@ -132,19 +213,67 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	globalUploadParams.File = filename
+	params := globalUploadParams
+	params.File = filename
+	params.EncodeOptions.Filename = handler.Filename
+	// Browsers send application/octet-stream for unknown types, let MultipartUpload detect it instead
+	if contentType := handler.Header.Get("Content-Type"); contentType != "application/octet-stream" {
+		params.EncodeOptions.ContentType = contentType
+	}
 
-	initialSlot, err := MultipartUpload(globalUploadParams)
+	initialSlot, err := MultipartUpload(params)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	writeUploadResponse(w, uploadedFile.Name(), initialSlot)
+}
+
+// saveUploadedFiles writes the files of a form to a new directory in uploads
+func saveUploadedFiles(parts []*multipart.FileHeader) (string, error) {
+	dir, err := os.MkdirTemp("uploads", "dir-")
+	if err != nil {
+		return "", err
+	}
+	for _, part := range parts {
+		filename, err := archiveEntryPath(dir, part.Filename)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(filename); err == nil {
+			return "", fmt.Errorf("duplicate file %s", part.Filename)
+		}
+		if err := saveUploadedFile(part, filename); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func saveUploadedFile(part *multipart.FileHeader, filename string) error {
+	file, err := part.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	uploadedFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer uploadedFile.Close()
+
+	_, err = io.Copy(uploadedFile, file)
+	return err
+}
+
+func writeUploadResponse(w http.ResponseWriter, filename string, initialSlot uint64) {
 	// Prepare JSON response
 	response := map[string]string{
 		"status":   "success",
 		"message":  "File uploaded successfully",
-		"filename": uploadedFile.Name(),
+		"filename": filename,
 		"slot":     strconv.Itoa(int(initialSlot)),
 	}
 
@@ -162,22 +291,56 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 func WebserverApp(cliCtx *cli.Context) error {
 	addr := cliCtx.String(TxRPCURLFlag.Name)
-	prv := cliCtx.String(TxPrivateKeyFlag.Name)
+	dense := cliCtx.Bool(TxDenseFlag.Name)
+	dataBlobs := cliCtx.Int(TxDataBlobsFlag.Name)
+	parityBlobs := cliCtx.Int(TxParityBlobsFlag.Name)
+	compression, err := parseCompression(cliCtx.String(TxCompressionFlag.Name))
+	if err != nil {
+		return err
+	}
+	if err := setupKZG(cliCtx); err != nil {
+		return err
+	}
+	signer, err := readSigner(context.Background(), cliCtx)
+	if err != nil {
+		return err
+	}
+
+	if parityBlobs > 0 {
+		if err := validateErasureOptions(dataBlobs, parityBlobs); err != nil {
+			return err
+		}
+	}
 
 	globalUploadParams = BlobUploadParams{
 		Host:             addr,
 		To:               common.HexToAddress("0x0000000000000000000000000000000000000000"),
-		PrivateKey:       prv,
+		Signer:           signer,
 		Value:            "0x0",
 		GasLimit:         21000,
 		GasPrice:         "800000000000",
 		PriorityGasPrice: "6000000000",
-		MaxFeePerBlobGas: "70000000000",
+		MaxFeePerBlobGas: cliCtx.String(TxMaxFeePerBlobGas.Name),
+		BlobFeeHeadroom:  cliCtx.Float64(TxBlobFeeHeadroomFlag.Name),
 		ChainID:          "7011893061",
 		Calldata:         "0x",
 		BlobsPerTx:       6,
+		MaxInFlight:      1,
+		BumpAfter:        cliCtx.Uint64(TxBumpAfterFlag.Name),
+		BumpPercent:      cliCtx.Uint64(TxBumpPercentFlag.Name),
+		MaxBumps:         cliCtx.Int(TxMaxBumpsFlag.Name),
+		Confirmations:    cliCtx.Uint64(TxConfirmationsFlag.Name),
+		ReceiptTimeout:   cliCtx.Duration(TxReceiptTimeoutFlag.Name),
+		EncodeOptions: EncodeOptions{
+			Dense:        dense,
+			DataShards:   dataBlobs,
+			ParityShards: parityBlobs,
+			Compression:  compression,
+			Concurrency:  cliCtx.Int(TxConcurrencyFlag.Name),
+		},
 	}
 
+	http.HandleFunc("/stream/", streamHandler)
 	http.HandleFunc("/stream/video", streamVideoHandler)
 	http.HandleFunc("/stream/image", streamImageHandler)
 	http.HandleFunc("/stream/svg", streamSvgHandler)
@@ -188,7 +351,7 @@ func WebserverApp(cliCtx *cli.Context) error {
 
 	port := 3333
 	fmt.Printf("Server listening on :%d\n", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 	}
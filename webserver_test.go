@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetContentHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	setContentHeaders(w, &Manifest{Filename: "DoD.jpg", ContentType: "image/jpeg"}, []byte("<html></html>"))
+	if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Fatalf("expected image/jpeg, got %s", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `inline; filename=DoD.jpg` {
+		t.Fatalf("unexpected Content-Disposition %s", got)
+	}
+
+	// Older uploads have no metadata
+	w = httptest.NewRecorder()
+	setContentHeaders(w, nil, []byte("<html><body>hello</body></html>"))
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Fatalf("expected text/html, got %s", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != "" {
+		t.Fatalf("unexpected Content-Disposition %s", got)
+	}
+}
+
+// A client disconnecting from /stream must not leave the download blocked on the channel
+func TestGetMultiPartBlobCancel(t *testing.T) {
+	blobs, err := encodeBlobsWithMagicHeader(makeBlob(200000), EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeBeaconNode{firstSlot: 100, blobs: blobs}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blobChannel := make(chan []byte)
+	errChannel := make(chan error, 1)
+	go func() {
+		errChannel <- GetMultiPartBlob(blobChannel, server.URL, node.firstSlot, DownloadOptions{Context: ctx})
+	}()
+	// Read the first chunk and stop, like a client closing the connection
+	<-blobChannel
+	cancel()
+
+	select {
+	case err := <-errChannel:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("download still running after the context was cancelled")
+	}
+}